@@ -0,0 +1,249 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAuditPrefix = "audit/"
+	redacted           = "[redacted]"
+)
+
+// fields which name the resources a request works on
+var auditTargetFields = []string{
+	"instanceid", "instanceids", "volumeid", "arn", "arns", "cluster", "function", "family", "destination",
+}
+
+// fields which are never recorded
+var auditSecretFields = map[string]bool{
+	"accesskeyid":     true,
+	"secretaccesskey": true,
+	"sessiontoken":    true,
+	"externalid":      true,
+}
+
+// AuditRecord is a line of the audit log
+type AuditRecord struct {
+	Time       time.Time    `json:"time"`
+	RequestId  string       `json:"requestid"`
+	SourceIP   string       `json:"sourceip"`
+	Identity   *Identity    `json:"identity,omitempty"`
+	Commands   []string     `json:"commands"`
+	Targets    []string     `json:"targets"`
+	Outcome    string       `json:"outcome"`
+	Code       int          `json:"code"`
+	DurationMs int64        `json:"durationms"`
+	Steps      []*AuditStep `json:"steps,omitempty"`
+	Errors     []string     `json:"errors,omitempty"`
+}
+
+type AuditStep struct {
+	Id      string                 `json:"id,omitempty"`
+	Command string                 `json:"command"`
+	Status  string                 `json:"status"`
+	Error   string                 `json:"error,omitempty"`
+	Params  map[string]interface{} `json:"params,omitempty"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+}
+
+func (r *AuditRecord) String() string {
+	return fmt.Sprintf("%s %s %s %s %d [%s] [%s]",
+		r.Time.Format(time.RFC3339), r.RequestId, r.SourceIP, r.Outcome, r.Code,
+		strings.Join(r.Commands, ","), strings.Join(r.Targets, ","))
+}
+
+// Has reports the record has the command which matches the pattern and the resource
+func (r *AuditRecord) Has(pattern, resource string) bool {
+	if pattern != "" {
+		found := false
+		for _, c := range r.Commands {
+			if MatchCommand(pattern, c) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if resource != "" {
+		for _, t := range r.Targets {
+			if t == resource {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func auditPrefix() string {
+	prefix := os.Getenv("AUDIT_PREFIX")
+	if prefix == "" {
+		prefix = defaultAuditPrefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return prefix
+}
+
+// auditDayPrefix returns the prefix of the day like audit/2022/10/01/
+func auditDayPrefix(t time.Time) string {
+	return auditPrefix() + t.UTC().Format("2006/01/02") + "/"
+}
+
+// NewAuditRecord creates the record of the session
+func (s *Session) NewAuditRecord(start time.Time) *AuditRecord {
+	r := &AuditRecord{
+		Time:       start.UTC(),
+		RequestId:  s.RequestId,
+		SourceIP:   s.SourceIP,
+		Identity:   s.Identity,
+		Commands:   []string{},
+		Targets:    []string{},
+		Code:       s.StatusCode(),
+		DurationMs: time.Since(start).Milliseconds(),
+		Errors:     s.Errors,
+	}
+	switch {
+	case r.Code == http.StatusOK:
+		r.Outcome = "ok"
+	case r.Code == http.StatusForbidden:
+		r.Outcome = "denied"
+	default:
+		r.Outcome = "error"
+	}
+	seen := map[string]bool{}
+	addTarget := func(v interface{}) {
+		var vals []string
+		switch v := v.(type) {
+		case string:
+			vals = []string{v}
+		case []string:
+			vals = v
+		case []interface{}:
+			for _, e := range v {
+				if str, ok := e.(string); ok {
+					vals = append(vals, str)
+				}
+			}
+		}
+		for _, val := range vals {
+			if val != "" && !seen[val] {
+				seen[val] = true
+				r.Targets = append(r.Targets, val)
+			}
+		}
+	}
+	for _, res := range s.Results {
+		r.Commands = append(r.Commands, res.Command)
+		step := &AuditStep{
+			Id:      res.Id,
+			Command: res.Command,
+			Status:  res.Status,
+			Error:   res.Error,
+			Params:  redact(res.params),
+			Outputs: redact(res.Outputs),
+		}
+		if res.sensitive {
+			step.Outputs = nil
+		}
+		for _, f := range auditTargetFields {
+			addTarget(res.params[f])
+			addTarget(res.Outputs[f])
+		}
+		r.Steps = append(r.Steps, step)
+	}
+	return r
+}
+
+// Audit writes the record of the invocation to the bucket.
+// Set AUDIT=off to disable it.
+func (s *Session) Audit(ctx context.Context, start time.Time) {
+	if os.Getenv("AUDIT") == "off" {
+		return
+	}
+	if len(s.Results) == 0 && len(s.Errors) == 0 {
+		return
+	}
+	if s.Bucket == nil {
+		s.Logf("audit: no bucket")
+		return
+	}
+	r := s.NewAuditRecord(start)
+	line, err := json.Marshal(r)
+	if err != nil {
+		s.Logf("audit: %v", err)
+		return
+	}
+	id := r.RequestId
+	if id == "" {
+		id = fmt.Sprintf("%d", start.UnixNano())
+	}
+	key := auditDayPrefix(r.Time) + r.Time.Format("150405.000") + "-" + id + ".jsonl"
+	// the session context may be done already
+	if err := s.Bucket.WithContext(ctx).Put(key, append(line, '\n')); err != nil {
+		s.Logf("audit: %v", err)
+	}
+}
+
+// ReadAuditRecords returns the records between since and until
+func (b *Bucket) ReadAuditRecords(since, until time.Time) ([]*AuditRecord, error) {
+	records := []*AuditRecord{}
+	day := since.UTC().Truncate(24 * time.Hour)
+	for !day.After(until) {
+		keys, err := b.List(auditDayPrefix(day))
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			body, err := b.Get(key)
+			if err != nil {
+				return nil, err
+			}
+			for _, line := range bytes.Split(body, []byte("\n")) {
+				if len(bytes.TrimSpace(line)) == 0 {
+					continue
+				}
+				r := &AuditRecord{}
+				if err := json.Unmarshal(line, r); err != nil {
+					return nil, fmt.Errorf("%s: %v", key, err)
+				}
+				if r.Time.Before(since) || r.Time.After(until) {
+					continue
+				}
+				records = append(records, r)
+			}
+		}
+		day = day.Add(24 * time.Hour)
+	}
+	return records, nil
+}
+
+// redact copies the map without secrets
+func redact(m map[string]interface{}) map[string]interface{} {
+	if len(m) == 0 {
+		return nil
+	}
+	out := map[string]interface{}{}
+	for k, v := range m {
+		if auditSecretFields[strings.ToLower(k)] {
+			out[k] = redacted
+			continue
+		}
+		if sub, ok := v.(map[string]interface{}); ok {
+			v = redact(sub)
+		}
+		out[k] = v
+	}
+	return out
+}
@@ -0,0 +1,78 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+const defaultAuditCount = 100
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name:        "audit.query",
+			Description: "search the audit log by command pattern, resource id and time range (default last 24h)",
+			Optional:    []string{"pattern", "resource", "since", "until", "count"},
+			Handler:     (*Session).doAuditQuery,
+		},
+	)
+}
+
+// parseTime parses RFC3339 or a duration before now like "24h"
+func parseTime(str string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(str); err == nil {
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, str)
+}
+
+func (s *Session) doAuditQuery(req PostRequest) {
+	if s.Bucket == nil {
+		s.Invalidf("audit.query: no bucket")
+		return
+	}
+	now := time.Now().UTC()
+	since := now.Add(-24 * time.Hour)
+	until := now
+	var err error
+	if req.Since != "" {
+		if since, err = parseTime(req.Since, now); err != nil {
+			s.Invalidf("since: %v", err)
+			return
+		}
+	}
+	if req.Until != "" {
+		if until, err = parseTime(req.Until, now); err != nil {
+			s.Invalidf("until: %v", err)
+			return
+		}
+	}
+	count := defaultAuditCount
+	if req.Count != nil {
+		count = int(*req.Count)
+		if count <= 0 {
+			s.Invalidf("count: %d must be positive", count)
+			return
+		}
+	}
+	records, err := s.Bucket.ReadAuditRecords(since, until)
+	if err != nil {
+		s.Errorf("ReadAuditRecords: %v", err)
+		return
+	}
+	matched := []*AuditRecord{}
+	for _, r := range records {
+		if r.Has(req.Pattern, req.Resource) {
+			matched = append(matched, r)
+		}
+	}
+	sort.SliceStable(matched, func(i, j int) bool {
+		return matched[i].Time.Before(matched[j].Time)
+	})
+	// the most recent ones
+	if len(matched) > count {
+		matched = matched[len(matched)-count:]
+	}
+	s.Items(matched)
+}
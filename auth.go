@@ -0,0 +1,384 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	HeaderSignature = "x-toolbox-signature"
+	HeaderTimestamp = "x-toolbox-timestamp"
+	HeaderKey       = "x-toolbox-key"
+
+	defaultHMACKey    = "default"
+	defaultHMACWindow = 5 * time.Minute
+	hmacSecretTTL     = 5 * time.Minute
+	defaultHostTTL    = time.Minute
+)
+
+// Identity is the authenticated caller
+type Identity struct {
+	// Method is the name of the authenticator which accepted the request
+	Method   string `json:"method"`
+	SourceIP string `json:"sourceip"`
+	// Key is the signing key id for hmac, or the queue, topic or rule name
+	Key string `json:"key,omitempty"`
+	// Source is the ARN of the queue, the topic or the rule of an event
+	Source string `json:"source,omitempty"`
+}
+
+func (id *Identity) String() string {
+	from := id.SourceIP
+	if id.Source != "" {
+		from = id.Source
+	}
+	if id.Key != "" {
+		return fmt.Sprintf("%s key=%s from %s", id.Method, id.Key, from)
+	}
+	return fmt.Sprintf("%s from %s", id.Method, from)
+}
+
+// Same reports whether the identities are the same caller, the key or the source IP without a key
+func (id *Identity) Same(other *Identity) bool {
+	if id == nil || other == nil {
+		return id == other
+	}
+	if id.Method != other.Method || id.Key != other.Key {
+		return false
+	}
+	return id.Key != "" || id.SourceIP == other.SourceIP
+}
+
+// Authenticator checks a request and returns the identity of the caller
+type Authenticator interface {
+	Name() string
+	Authenticate(ctx context.Context, req events.LambdaFunctionURLRequest, body []byte) (*Identity, error)
+}
+
+// NewAuthenticators returns the configured authenticators.
+// AUTH lists them like "ip,hmac", by default ip and also hmac when a secret is set.
+func NewAuthenticators() ([]Authenticator, error) {
+	names := os.Getenv("AUTH")
+	if names == "" {
+		names = "ip"
+		if os.Getenv("HMAC_SECRET") != "" || os.Getenv("HMAC_SECRET_ID") != "" {
+			names += ",hmac"
+		}
+	}
+	auths := []Authenticator{}
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "ip":
+			auth, err := newIPAuthenticator()
+			if err != nil {
+				return nil, err
+			}
+			auths = append(auths, auth)
+		case "hmac":
+			auths = append(auths, newHMACAuthenticator())
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown authenticator %s", name)
+		}
+	}
+	return auths, nil
+}
+
+// authenticate sets the identity and returns false when the request is denied.
+// Any authenticator can accept the request, AUTH_REQUIRE=all requires all of them.
+func (s *Session) authenticate(req events.LambdaFunctionURLRequest, body []byte) bool {
+	auths, err := NewAuthenticators()
+	if err != nil {
+		s.Failf(http.StatusInternalServerError, "auth: %v", err)
+		return false
+	}
+	requireAll := os.Getenv("AUTH_REQUIRE") == "all"
+	var identity *Identity
+	errs := []string{}
+	// all of them run so that a signed request from an allowed IP has the key
+	for _, auth := range auths {
+		id, err := auth.Authenticate(s.ctx, req, body)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("auth: %s: %v", auth.Name(), err))
+			continue
+		}
+		// the signing key identifies the caller better than the source IP
+		if identity == nil || id.Key != "" {
+			identity = id
+		}
+	}
+	if identity == nil || (requireAll && len(errs) > 0) {
+		s.LogLines(errs)
+		s.Failf(http.StatusForbidden, "request from %s is NOT allowed", req.RequestContext.HTTP.SourceIP)
+		return false
+	}
+	s.Identity = identity
+	s.Logf("auth: %v", identity)
+	return true
+}
+
+// ipAuthenticator accepts requests from ALLOWED_IPS and ALLOWED_HOSTS.
+// ALLOWED_IPS has IPv4 or IPv6 addresses and CIDR ranges.
+type ipAuthenticator struct {
+	nets  []*net.IPNet
+	hosts []string
+}
+
+func newIPAuthenticator() (*ipAuthenticator, error) {
+	a := &ipAuthenticator{
+		hosts: splitList(os.Getenv("ALLOWED_HOSTS")),
+	}
+	for _, entry := range splitList(os.Getenv("ALLOWED_IPS")) {
+		ipnet, err := ParseIPNet(entry)
+		if err != nil {
+			return nil, fmt.Errorf("ALLOWED_IPS: %v", err)
+		}
+		a.nets = append(a.nets, ipnet)
+	}
+	return a, nil
+}
+
+func (a *ipAuthenticator) Name() string {
+	return "ip"
+}
+
+func (a *ipAuthenticator) Authenticate(ctx context.Context, req events.LambdaFunctionURLRequest, body []byte) (*Identity, error) {
+	sourceip := req.RequestContext.HTTP.SourceIP
+	ip := net.ParseIP(sourceip)
+	if ip == nil {
+		return nil, fmt.Errorf("bad SourceIP: %s", sourceip)
+	}
+	id := &Identity{Method: a.Name(), SourceIP: sourceip}
+	for _, ipnet := range a.nets {
+		if ipnet.Contains(ip) {
+			return id, nil
+		}
+	}
+	for _, host := range a.hosts {
+		for _, addr := range resolvedHosts.lookup(host) {
+			if addr.Equal(ip) {
+				return id, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("SourceIP: %s is NOT allowed", sourceip)
+}
+
+// ParseIPNet parses a CIDR range or a single address as a range of the address only
+func ParseIPNet(entry string) (*net.IPNet, error) {
+	if strings.Contains(entry, "/") {
+		_, ipnet, err := net.ParseCIDR(entry)
+		return ipnet, err
+	}
+	ip := net.ParseIP(entry)
+	if ip == nil {
+		return nil, fmt.Errorf("bad address %s", entry)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// hostCache keeps the resolved addresses of hosts across warm invocations
+type hostCache struct {
+	addrs map[string][]net.IP
+	until map[string]time.Time
+	mu    sync.Mutex
+}
+
+var lookupIP = net.LookupIP
+
+var resolvedHosts = &hostCache{
+	addrs: map[string][]net.IP{},
+	until: map[string]time.Time{},
+}
+
+// lookup returns all A and AAAA records of the host.
+// They are cached for ALLOWED_HOSTS_TTL seconds (default 60), failures too.
+func (c *hostCache) lookup(host string) []net.IP {
+	now := time.Now()
+	c.mu.Lock()
+	if t, ok := c.until[host]; ok && now.Before(t) {
+		addrs := c.addrs[host]
+		c.mu.Unlock()
+		return addrs
+	}
+	c.mu.Unlock()
+	ttl := defaultHostTTL
+	if sec, err := strconv.Atoi(os.Getenv("ALLOWED_HOSTS_TTL")); err == nil && sec >= 0 {
+		ttl = time.Duration(sec) * time.Second
+	}
+	// a slow resolver does not block the other hosts
+	addrs, err := lookupIP(host)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		// keep the previous addresses on a temporary failure
+		addrs = c.addrs[host]
+	}
+	c.addrs[host] = addrs
+	c.until[host] = now.Add(ttl)
+	return addrs
+}
+
+// hmacAuthenticator accepts requests signed with a shared secret.
+// The signature is the hex encoded HMAC-SHA256 of the timestamp, a newline and the raw body.
+type hmacAuthenticator struct {
+	window time.Duration
+}
+
+func newHMACAuthenticator() *hmacAuthenticator {
+	window := defaultHMACWindow
+	if sec, err := strconv.Atoi(os.Getenv("HMAC_WINDOW")); err == nil && sec > 0 {
+		window = time.Duration(sec) * time.Second
+	}
+	return &hmacAuthenticator{window: window}
+}
+
+func (a *hmacAuthenticator) Name() string {
+	return "hmac"
+}
+
+func (a *hmacAuthenticator) Authenticate(ctx context.Context, req events.LambdaFunctionURLRequest, body []byte) (*Identity, error) {
+	sig := req.Headers[HeaderSignature]
+	ts := req.Headers[HeaderTimestamp]
+	if sig == "" || ts == "" {
+		return nil, fmt.Errorf("no signature")
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("bad timestamp %s", ts)
+	}
+	now := time.Now()
+	diff := now.Sub(time.Unix(sec, 0))
+	if diff > a.window || diff < -a.window {
+		return nil, fmt.Errorf("timestamp %s is out of window", ts)
+	}
+	key := req.Headers[HeaderKey]
+	if key == "" {
+		key = defaultHMACKey
+	}
+	secrets, err := hmacSecrets.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	secret, ok := secrets[key]
+	if !ok {
+		return nil, fmt.Errorf("unknown key %s", key)
+	}
+	want, err := hex.DecodeString(sig)
+	if err != nil {
+		return nil, fmt.Errorf("bad signature")
+	}
+	if !hmac.Equal(want, SignBody([]byte(secret), ts, body)) {
+		return nil, fmt.Errorf("signature mismatch")
+	}
+	// hex is decoded in any case, the same signature in upper case is a replay
+	if !signatures.add(hex.EncodeToString(want), now, a.window) {
+		return nil, fmt.Errorf("replayed request")
+	}
+	return &Identity{Method: a.Name(), SourceIP: req.RequestContext.HTTP.SourceIP, Key: key}, nil
+}
+
+// SignBody returns the HMAC-SHA256 of the timestamp and the body
+func SignBody(secret []byte, ts string, body []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(ts))
+	mac.Write([]byte("\n"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// secretCache keeps the signing keys across warm invocations
+type secretCache struct {
+	keys   map[string]string
+	expire time.Time
+	mu     sync.Mutex
+}
+
+var hmacSecrets = &secretCache{}
+
+// get returns the keys from HMAC_SECRET or the Secrets Manager secret HMAC_SECRET_ID.
+// The secret is a single key or a JSON object of key id and secret.
+func (c *secretCache) get(ctx context.Context) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.keys != nil && time.Now().Before(c.expire) {
+		return c.keys, nil
+	}
+	secret := os.Getenv("HMAC_SECRET")
+	if id := os.Getenv("HMAC_SECRET_ID"); secret == "" && id != "" {
+		cli, err := NewSecretsManagerClient(ctx)
+		if err != nil {
+			return nil, err
+		}
+		secret, err = cli.GetSecretString(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if secret == "" {
+		return nil, fmt.Errorf("no secret")
+	}
+	keys := map[string]string{}
+	if strings.HasPrefix(secret, "{") {
+		if err := json.Unmarshal([]byte(secret), &keys); err != nil {
+			return nil, fmt.Errorf("bad secret: %v", err)
+		}
+	} else {
+		keys[defaultHMACKey] = secret
+	}
+	c.keys = keys
+	c.expire = time.Now().Add(hmacSecretTTL)
+	return keys, nil
+}
+
+// signatureCache remembers the signatures seen in the window to reject replays
+// in the same container
+type signatureCache struct {
+	seen map[string]time.Time
+	mu   sync.Mutex
+}
+
+var signatures = &signatureCache{seen: map[string]time.Time{}}
+
+// add returns false when the signature has been seen
+func (c *signatureCache) add(sig string, now time.Time, window time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k, t := range c.seen {
+		if now.Sub(t) > 2*window {
+			delete(c.seen, k)
+		}
+	}
+	if _, ok := c.seen[sig]; ok {
+		return false
+	}
+	c.seen[sig] = now
+	return true
+}
+
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
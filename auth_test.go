@@ -0,0 +1,259 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestSignBody(t *testing.T) {
+	tests := []struct {
+		secret string
+		ts     string
+		body   string
+		want   string
+	}{
+		{"secret", "1664582400", `{"command":"help"}`, "012ebf8b4a05ec06aa1e3a397949a2d83e2630f744cdb950491563c5a3edeaf1"},
+	}
+	for _, tt := range tests {
+		got := hex.EncodeToString(SignBody([]byte(tt.secret), tt.ts, []byte(tt.body)))
+		if got != tt.want {
+			t.Errorf("SignBody(%s, %s) = %s want %s", tt.ts, tt.body, got, tt.want)
+		}
+	}
+	// the timestamp is a part of the signature
+	a := SignBody([]byte("secret"), "1", []byte("body"))
+	b := SignBody([]byte("secret"), "2", []byte("body"))
+	if hex.EncodeToString(a) == hex.EncodeToString(b) {
+		t.Errorf("same signature for different timestamps")
+	}
+}
+
+func useTestSecrets(t *testing.T) {
+	prev := hmacSecrets
+	hmacSecrets = &secretCache{
+		keys:   map[string]string{"default": "secret", "ci": "cisecret"},
+		expire: time.Now().Add(time.Hour),
+	}
+	t.Cleanup(func() { hmacSecrets = prev })
+}
+
+func signedRequest(secret, key string, ts time.Time, body string) events.LambdaFunctionURLRequest {
+	sts := strconv.FormatInt(ts.Unix(), 10)
+	req := events.LambdaFunctionURLRequest{
+		Headers: map[string]string{
+			HeaderTimestamp: sts,
+			HeaderSignature: hex.EncodeToString(SignBody([]byte(secret), sts, []byte(body))),
+		},
+	}
+	req.RequestContext.HTTP.SourceIP = "192.0.2.1"
+	if key != "" {
+		req.Headers[HeaderKey] = key
+	}
+	return req
+}
+
+func TestHMACAuthenticate(t *testing.T) {
+	useTestSecrets(t)
+	a := &hmacAuthenticator{window: 5 * time.Minute}
+	body := `{"command":"help"}`
+	now := time.Now()
+	badhex := signedRequest("secret", "", now, body)
+	badhex.Headers[HeaderSignature] = "zz"
+	badts := signedRequest("secret", "", now, body)
+	badts.Headers[HeaderTimestamp] = "yesterday"
+	tests := []struct {
+		name string
+		req  events.LambdaFunctionURLRequest
+		body string
+		key  string
+		ok   bool
+	}{
+		{"default key", signedRequest("secret", "", now, body), body, "default", true},
+		{"named key", signedRequest("cisecret", "ci", now, body), body, "ci", true},
+		{"wrong secret", signedRequest("other", "", now, body), body, "", false},
+		{"wrong key", signedRequest("cisecret", "nokey", now, body), body, "", false},
+		{"changed body", signedRequest("secret", "", now, body), `{"command":"ec2.terminate"}`, "", false},
+		{"old", signedRequest("secret", "", now.Add(-10*time.Minute), body), body, "", false},
+		{"future", signedRequest("secret", "", now.Add(10*time.Minute), body), body, "", false},
+		{"in window", signedRequest("secret", "", now.Add(-4*time.Minute), body), body, "default", true},
+		{"bad hex", badhex, body, "", false},
+		{"bad timestamp", badts, body, "", false},
+		{"no signature", events.LambdaFunctionURLRequest{}, body, "", false},
+	}
+	for _, tt := range tests {
+		id, err := a.Authenticate(context.Background(), tt.req, []byte(tt.body))
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err %v", tt.name, err)
+			continue
+		}
+		if tt.ok && id.Key != tt.key {
+			t.Errorf("%s: key %s want %s", tt.name, id.Key, tt.key)
+		}
+	}
+}
+
+func TestHMACReplay(t *testing.T) {
+	useTestSecrets(t)
+	a := &hmacAuthenticator{window: 5 * time.Minute}
+	body := `{"command":"help","id":"replay"}`
+	req := signedRequest("secret", "", time.Now(), body)
+	if _, err := a.Authenticate(context.Background(), req, []byte(body)); err != nil {
+		t.Fatalf("first: %v", err)
+	}
+	if _, err := a.Authenticate(context.Background(), req, []byte(body)); err == nil {
+		t.Errorf("replayed request is accepted")
+	}
+	upper := signedRequest("secret", "", time.Now(), body)
+	upper.Headers[HeaderSignature] = strings.ToUpper(req.Headers[HeaderSignature])
+	upper.Headers[HeaderTimestamp] = req.Headers[HeaderTimestamp]
+	if _, err := a.Authenticate(context.Background(), upper, []byte(body)); err == nil {
+		t.Errorf("replayed request in upper case is accepted")
+	}
+	// a failed attempt does not use up the signature
+	body2 := `{"command":"help","id":"replay2"}`
+	req2 := signedRequest("secret", "", time.Now(), body2)
+	a.Authenticate(context.Background(), req2, []byte("tampered"))
+	if _, err := a.Authenticate(context.Background(), req2, []byte(body2)); err != nil {
+		t.Errorf("after a failure: %v", err)
+	}
+}
+
+func TestSignatureCacheExpire(t *testing.T) {
+	c := &signatureCache{seen: map[string]time.Time{}}
+	now := time.Now()
+	if !c.add("sig", now, time.Minute) {
+		t.Fatal("first add")
+	}
+	if c.add("sig", now.Add(time.Minute), time.Minute) {
+		t.Errorf("seen signature is added")
+	}
+	if !c.add("sig", now.Add(3*time.Minute), time.Minute) {
+		t.Errorf("expired signature is not added")
+	}
+}
+
+func TestAuthenticateSignedFromAllowedIP(t *testing.T) {
+	useTestSecrets(t)
+	t.Setenv("AUTH", "ip,hmac")
+	t.Setenv("ALLOWED_IPS", "192.0.2.0/24")
+	body := `{"command":"help","id":"allowed"}`
+	s := &Session{ctx: context.Background()}
+	if !s.authenticate(signedRequest("cisecret", "ci", time.Now(), body), []byte(body)) {
+		t.Fatalf("denied: %v", s.Outputs)
+	}
+	if s.Identity.Method != "hmac" || s.Identity.Key != "ci" {
+		t.Errorf("identity %v", s.Identity)
+	}
+	t.Setenv("AUTH_REQUIRE", "all")
+	s = &Session{ctx: context.Background()}
+	if s.authenticate(signedRequest("other", "", time.Now(), body), []byte(body)) {
+		t.Errorf("bad signature is accepted with AUTH_REQUIRE=all")
+	}
+}
+
+func TestIdentitySame(t *testing.T) {
+	ci := &Identity{Method: "hmac", SourceIP: "192.0.2.1", Key: "ci"}
+	ip := &Identity{Method: "ip", SourceIP: "192.0.2.1"}
+	tests := []struct {
+		a, b *Identity
+		want bool
+	}{
+		{ci, &Identity{Method: "hmac", SourceIP: "198.51.100.1", Key: "ci"}, true},
+		{ci, &Identity{Method: "hmac", SourceIP: "192.0.2.1", Key: "ops"}, false},
+		{ci, &Identity{Method: "sqs", Key: "ci"}, false},
+		{ci, ip, false},
+		{ip, &Identity{Method: "ip", SourceIP: "192.0.2.1"}, true},
+		{ip, &Identity{Method: "ip", SourceIP: "192.0.2.2"}, false},
+		{ip, nil, false},
+		{nil, nil, true},
+	}
+	for _, tt := range tests {
+		if got := tt.a.Same(tt.b); got != tt.want {
+			t.Errorf("%v %v: same = %v", tt.a, tt.b, got)
+		}
+	}
+}
+
+func TestParseIPNet(t *testing.T) {
+	tests := []struct {
+		entry    string
+		contains []string
+		excludes []string
+		err      bool
+	}{
+		{"192.0.2.1", []string{"192.0.2.1"}, []string{"192.0.2.2", "::ffff:192.0.2.2"}, false},
+		{"192.0.2.0/24", []string{"192.0.2.1", "192.0.2.255", "::ffff:192.0.2.3"}, []string{"192.0.3.1"}, false},
+		{"2001:db8::1", []string{"2001:db8::1"}, []string{"2001:db8::2"}, false},
+		{"2001:db8::/32", []string{"2001:db8::1", "2001:db8:ffff::1"}, []string{"2001:db9::1", "192.0.2.1"}, false},
+		{"0.0.0.0/0", []string{"198.51.100.1"}, nil, false},
+		{"192.0.2.1/33", nil, nil, true},
+		{"192.0.2", nil, nil, true},
+		{"example.com", nil, nil, true},
+		{"", nil, nil, true},
+	}
+	for _, tt := range tests {
+		ipnet, err := ParseIPNet(tt.entry)
+		if (err != nil) != tt.err {
+			t.Errorf("%q: err %v", tt.entry, err)
+			continue
+		}
+		if tt.err {
+			continue
+		}
+		for _, ip := range tt.contains {
+			if !ipnet.Contains(net.ParseIP(ip)) {
+				t.Errorf("%s does not contain %s", tt.entry, ip)
+			}
+		}
+		for _, ip := range tt.excludes {
+			if ipnet.Contains(net.ParseIP(ip)) {
+				t.Errorf("%s contains %s", tt.entry, ip)
+			}
+		}
+	}
+}
+
+func TestHostCache(t *testing.T) {
+	prev := lookupIP
+	t.Cleanup(func() { lookupIP = prev })
+	calls := 0
+	fail := false
+	lookupIP = func(host string) ([]net.IP, error) {
+		calls++
+		if fail {
+			return nil, fmt.Errorf("no such host")
+		}
+		return []net.IP{net.ParseIP("192.0.2.1")}, nil
+	}
+	t.Setenv("ALLOWED_HOSTS_TTL", "60")
+	c := &hostCache{addrs: map[string][]net.IP{}, until: map[string]time.Time{}}
+	if addrs := c.lookup("ok.example"); len(addrs) != 1 || calls != 1 {
+		t.Fatalf("lookup %v calls %d", addrs, calls)
+	}
+	c.lookup("ok.example")
+	if calls != 1 {
+		t.Errorf("cached host is resolved again")
+	}
+	fail = true
+	if addrs := c.lookup("bad.example"); addrs != nil || calls != 2 {
+		t.Errorf("failed lookup %v calls %d", addrs, calls)
+	}
+	c.lookup("bad.example")
+	if calls != 2 {
+		t.Errorf("failed host is resolved again")
+	}
+	// the previous addresses are kept on a failure
+	c.until["ok.example"] = time.Now()
+	if addrs := c.lookup("ok.example"); len(addrs) != 1 || calls != 3 {
+		t.Errorf("expired lookup %v calls %d", addrs, calls)
+	}
+}
@@ -0,0 +1,120 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// configCache keeps the loaded configs across warm invocations
+type configCache struct {
+	configs map[string]aws.Config
+	mu      sync.Mutex
+}
+
+var awsConfigs = &configCache{configs: map[string]aws.Config{}}
+
+// LoadAWSConfig loads the config of the shared config profile once, "" is the default config
+func LoadAWSConfig(ctx context.Context, profile string) (aws.Config, error) {
+	awsConfigs.mu.Lock()
+	defer awsConfigs.mu.Unlock()
+	if cfg, ok := awsConfigs.configs[profile]; ok {
+		return cfg, nil
+	}
+	opts := []func(*config.LoadOptions) error{}
+	if profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(profile))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	awsConfigs.configs[profile] = cfg
+	return cfg, nil
+}
+
+// awsConfig returns the config for the request with its profile, region and role
+func (s *Session) awsConfig(req PostRequest) (aws.Config, error) {
+	cfg, err := LoadAWSConfig(s.ctx, req.Profile)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	if req.Region != "" {
+		cfg = cfg.Copy()
+		cfg.Region = req.Region
+	}
+	if req.Role != nil {
+		cfg = cfg.Copy()
+		cfg.Credentials = roleCredentials.get(cfg, req.Profile, *req.Role)
+	}
+	return cfg, nil
+}
+
+// Role is the IAM role which runs the commands
+type Role struct {
+	ARN         string `json:"arn"`
+	ExternalId  string `json:"externalid,omitempty"`
+	SessionName string `json:"sessionname,omitempty"`
+}
+
+const (
+	defaultRoleSessionName = "lambda-toolbox"
+	// renew the credentials before they expire
+	roleExpiryWindow = 5 * time.Minute
+)
+
+// roleProvider assumes the role with the base config
+type roleProvider struct {
+	cfg  aws.Config
+	role Role
+}
+
+func (p *roleProvider) Retrieve(ctx context.Context) (aws.Credentials, error) {
+	session := p.role.SessionName
+	if session == "" {
+		session = defaultRoleSessionName
+	}
+	cli := NewSTSClient(ctx, p.cfg)
+	cred, err := cli.AssumeRoleWith(p.role.ARN, p.role.ExternalId, session)
+	if err != nil {
+		return aws.Credentials{}, err
+	}
+	creds := aws.Credentials{
+		AccessKeyID:     strval(cred.AccessKeyId),
+		SecretAccessKey: strval(cred.SecretAccessKey),
+		SessionToken:    strval(cred.SessionToken),
+		Source:          "AssumeRole",
+	}
+	if cred.Expiration != nil {
+		creds.CanExpire = true
+		creds.Expires = *cred.Expiration
+	}
+	return creds, nil
+}
+
+// roleCache keeps the credentials of the roles across warm invocations until near expiry
+type roleCache struct {
+	providers map[string]*aws.CredentialsCache
+	mu        sync.Mutex
+}
+
+var roleCredentials = &roleCache{providers: map[string]*aws.CredentialsCache{}}
+
+func (c *roleCache) get(cfg aws.Config, profile string, role Role) *aws.CredentialsCache {
+	key := strings.Join([]string{profile, role.ARN, role.ExternalId, role.SessionName}, "\x00")
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if p, ok := c.providers[key]; ok {
+		return p
+	}
+	p := aws.NewCredentialsCache(&roleProvider{cfg: cfg, role: role}, func(o *aws.CredentialsCacheOptions) {
+		o.ExpiryWindow = roleExpiryWindow
+	})
+	c.providers[key] = p
+	return p
+}
@@ -0,0 +1,165 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	OnErrorContinue = "continue"
+	OnErrorStop     = "stop"
+	OnErrorRollback = "rollback"
+)
+
+// handleBatch runs the sub-requests and returns false when any of them failed
+func (s *Session) handleBatch(req PostRequest) bool {
+	if err := batchCommand.Validate(req); err != nil {
+		s.Invalidf("%v", err)
+		return false
+	}
+	switch req.OnError {
+	case "", OnErrorContinue, OnErrorStop, OnErrorRollback:
+	default:
+		s.Invalidf("batch: unknown onerror %s", req.OnError)
+		return false
+	}
+	if req.If != "" {
+		ok, err := s.evalCondition(req.If)
+		if err != nil {
+			s.Invalidf("batch: if: %v", err)
+			return false
+		}
+		if !ok {
+			s.Logf("batch: skipped by condition %s", req.If)
+			for _, r := range req.Requests {
+				s.skip(r)
+			}
+			return true
+		}
+	}
+	stopOnError := req.OnError != "" && req.OnError != OnErrorContinue
+	reqs := make([]PostRequest, len(req.Requests))
+	for i, r := range req.Requests {
+		reqs[i] = inherit(req, r)
+	}
+	start := len(s.Results)
+	failed := false
+	if req.Parallel {
+		failed = !s.handleParallel(reqs, req.Concurrency, stopOnError)
+	} else {
+		for _, r := range reqs {
+			if failed && stopOnError {
+				s.skip(r)
+				continue
+			}
+			if !s.handlePostRequest(r) {
+				failed = true
+			}
+		}
+	}
+	if failed && req.OnError == OnErrorRollback {
+		s.rollback(s.Results[start:])
+	}
+	return !failed
+}
+
+// inherit applies the batch level settings to the sub-request
+func inherit(parent, child PostRequest) PostRequest {
+	if parent.DryRun {
+		child.DryRun = true
+	}
+	if child.Region == "" {
+		child.Region = parent.Region
+	}
+	if child.Profile == "" {
+		child.Profile = parent.Profile
+	}
+	if child.Role == nil {
+		child.Role = parent.Role
+	}
+	if child.Format == "" {
+		child.Format = parent.Format
+	}
+	return child
+}
+
+// skip records the request and its sub-requests as skipped
+func (s *Session) skip(req PostRequest) {
+	if req.Command == "" {
+		for _, r := range req.Requests {
+			s.skip(r)
+		}
+		return
+	}
+	res := &Result{Id: req.Id, Command: req.Command, Status: StatusSkipped}
+	s.Results = append(s.Results, res)
+	if req.Id != "" {
+		s.steps.set(req.Id, res)
+	}
+	s.Logf("%s: skipped", req.Command)
+}
+
+// rollback undoes succeeded steps in reverse order
+func (s *Session) rollback(results []*Result) {
+	for i := len(results) - 1; i >= 0; i-- {
+		res := results[i]
+		// undo steps of a nested rollback are not undone again
+		if res.Status != StatusOK || res.DryRun || res.Undo {
+			continue
+		}
+		cmd, _ := LookupCommand(res.Command)
+		if cmd == nil || cmd.Rollback == nil {
+			continue
+		}
+		undo := cmd.Rollback(res)
+		if undo == nil {
+			continue
+		}
+		// in the same region as the step
+		*undo = inherit(res.req, *undo)
+		s.Logf("rollback %s by %s", res.Command, undo.Command)
+		n := len(s.Results)
+		ok := s.handlePostRequest(*undo)
+		for _, r := range s.Results[n:] {
+			r.Undo = true
+		}
+		if ok {
+			res.Status = StatusRolledBack
+		}
+	}
+}
+
+// evalCondition evaluates "a == b", "a != b" or a single value after replacing references
+func (s *Session) evalCondition(cond string) (bool, error) {
+	v, err := s.expandString(cond)
+	if err != nil {
+		return false, err
+	}
+	str := fmt.Sprintf("%v", v)
+	if a := strings.SplitN(str, "!=", 2); len(a) == 2 {
+		return strings.TrimSpace(a[0]) != strings.TrimSpace(a[1]), nil
+	}
+	if a := strings.SplitN(str, "==", 2); len(a) == 2 {
+		return strings.TrimSpace(a[0]) == strings.TrimSpace(a[1]), nil
+	}
+	switch strings.TrimSpace(str) {
+	case "", "false", "no", "0":
+		return false, nil
+	}
+	return true, nil
+}
+
+func outputString(res *Result, key string) *string {
+	if v, ok := res.Outputs[key].(string); ok {
+		return &v
+	}
+	return nil
+}
+
+func outputStrings(res *Result, key string) []string {
+	if v, ok := res.Outputs[key].([]string); ok {
+		return v
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Result and Response are the JSON response of the toolbox
+type Result struct {
+	Id      string                 `json:"id,omitempty"`
+	Command string                 `json:"command"`
+	Status  string                 `json:"status"`
+	Code    int                    `json:"code"`
+	Error   string                 `json:"error,omitempty"`
+	DryRun  bool                   `json:"dryrun,omitempty"`
+	Data    interface{}            `json:"data,omitempty"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+	Logs    []string               `json:"logs"`
+}
+
+type Response struct {
+	Code    int       `json:"code"`
+	Summary string    `json:"summary"`
+	Results []*Result `json:"results"`
+	Errors  []string  `json:"errors,omitempty"`
+	Logs    []string  `json:"logs"`
+	// raw body
+	raw []byte
+}
+
+// Client sends requests to the toolbox URL.
+// Requests are signed when Secret is set.
+type Client struct {
+	URL     string
+	Secret  string
+	Key     string
+	Retries int
+	http    *http.Client
+}
+
+func NewClient(url string, timeout time.Duration) *Client {
+	return &Client{
+		URL:     url,
+		Retries: 3,
+		http:    &http.Client{Timeout: timeout},
+	}
+}
+
+func (c *Client) sign(hreq *http.Request, body []byte) {
+	if c.Secret == "" {
+		return
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(c.Secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("\n"))
+	mac.Write(body)
+	hreq.Header.Set("x-toolbox-timestamp", ts)
+	hreq.Header.Set("x-toolbox-signature", hex.EncodeToString(mac.Sum(nil)))
+	if c.Key != "" {
+		hreq.Header.Set("x-toolbox-key", c.Key)
+	}
+}
+
+// post sends the body once
+func (c *Client) post(ctype string, body []byte) (*Response, error) {
+	hreq, err := http.NewRequest("POST", c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	hreq.Header.Set("content-type", ctype)
+	hreq.Header.Set("accept", "application/json")
+	c.sign(hreq, body)
+	hresp, err := c.http.Do(hreq)
+	if err != nil {
+		return nil, err
+	}
+	defer hresp.Body.Close()
+	raw, err := io.ReadAll(hresp.Body)
+	if err != nil {
+		return nil, err
+	}
+	resp := &Response{}
+	if err := json.Unmarshal(raw, resp); err != nil {
+		// not a toolbox response
+		return nil, fmt.Errorf("%s: %s", hresp.Status, bytes.TrimSpace(raw))
+	}
+	resp.Code = hresp.StatusCode
+	resp.raw = raw
+	return resp, nil
+}
+
+// Do sends the request
+func (c *Client) Do(req interface{}) (*Response, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	return c.post("application/json", body)
+}
+
+// Upload sends the file as a form part, retrying on network and server errors
+func (c *Client) Upload(field, filename string, data []byte) (*Response, error) {
+	buf := &bytes.Buffer{}
+	w := multipart.NewWriter(buf)
+	part, err := w.CreateFormFile(field, filename)
+	if err != nil {
+		return nil, err
+	}
+	part.Write(data)
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	var resp *Response
+	wait := time.Second
+	for i := 0; ; i++ {
+		// signed again with the new timestamp
+		resp, err = c.post(w.FormDataContentType(), buf.Bytes())
+		if err == nil && resp.Code < 500 {
+			return resp, nil
+		}
+		if i >= c.Retries {
+			break
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "upload %s: %v, retrying\n", filename, err)
+		} else {
+			fmt.Fprintf(os.Stderr, "upload %s: %d, retrying\n", filename, resp.Code)
+		}
+		time.Sleep(wait)
+		wait *= 2
+	}
+	return resp, err
+}
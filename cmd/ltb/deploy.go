@@ -0,0 +1,101 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// uploads must fit in a Lambda payload
+const defaultChunkSize = 4 * 1024 * 1024
+
+// chunks splits the data like split -d
+func chunks(name string, data []byte, size int) ([]string, [][]byte) {
+	names := []string{}
+	parts := [][]byte{}
+	for i := 0; len(data) > 0; i++ {
+		n := size
+		if n > len(data) {
+			n = len(data)
+		}
+		names = append(names, fmt.Sprintf("%s.%02d", name, i))
+		parts = append(parts, data[:n])
+		data = data[n:]
+	}
+	return names, parts
+}
+
+// uploadFile uploads the file in chunks and returns the request which joins them.
+// The file is stored in /tmp or under tmp/ in the bucket with s3.
+func (c *Client) uploadFile(path string, s3 bool, size int) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(path)
+	field := "tmp"
+	if s3 {
+		field = "s3"
+	}
+	names, parts := chunks(base, data, size)
+	for i, name := range names {
+		fmt.Fprintf(os.Stderr, "upload %s (%d bytes)\n", name, len(parts[i]))
+		resp, err := c.Upload(field, name, parts[i])
+		if err != nil {
+			return nil, err
+		}
+		if resp.Code != 200 {
+			return nil, fmt.Errorf("upload %s: %d %s", name, resp.Code, lastLog(resp))
+		}
+	}
+	if s3 {
+		sources := []string{}
+		for _, name := range names {
+			sources = append(sources, "tmp/"+name)
+		}
+		return map[string]interface{}{
+			"command":     "s3.concat",
+			"destination": "tmp/" + base,
+			"sources":     sources,
+		}, nil
+	}
+	return map[string]interface{}{
+		"command":     "exec.concat",
+		"destination": base,
+		"sources":     names,
+	}, nil
+}
+
+// Deploy uploads the zip, stores it in the bucket and updates the function code
+func (c *Client) Deploy(function, zip string, size int) (*Response, error) {
+	concat, err := c.uploadFile(zip, false, size)
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(zip)
+	batch := map[string]interface{}{
+		"onerror": "stop",
+		"requests": []interface{}{
+			concat,
+			map[string]interface{}{
+				"command":     "s3.store",
+				"destination": "code",
+				"sources":     []string{base},
+			},
+			map[string]interface{}{
+				"command":  "lambda.update",
+				"function": function,
+				"zipfile":  "code/" + base,
+			},
+		},
+	}
+	return c.Do(batch)
+}
+
+func lastLog(resp *Response) string {
+	if len(resp.Logs) == 0 {
+		return ""
+	}
+	return resp.Logs[len(resp.Logs)-1]
+}
@@ -0,0 +1,68 @@
+// Code generated by genfields.go; DO NOT EDIT.
+
+package main
+
+// request fields of the toolbox by json name
+var fieldKinds = map[string]fieldKind{
+	"id":                kindString,
+	"if":                kindString,
+	"onerror":           kindString,
+	"parallel":          kindBool,
+	"concurrency":       kindInt,
+	"dryrun":            kindBool,
+	"region":            kindString,
+	"profile":           kindString,
+	"fanout":            kindBool,
+	"accounts":          kindList,
+	"regions":           kindList,
+	"function":          kindString,
+	"zipfile":           kindString,
+	"destination":       kindString,
+	"sources":           kindList,
+	"arn":               kindString,
+	"arns":              kindList,
+	"instanceid":        kindString,
+	"instanceids":       kindList,
+	"vpcid":             kindString,
+	"subnetid":          kindString,
+	"associatepublicip": kindBool,
+	"imageid":           kindString,
+	"instancetype":      kindString,
+	"keyname":           kindString,
+	"securitygroupids":  kindList,
+	"az":                kindString,
+	"volumeid":          kindString,
+	"device":            kindString,
+	"userdatafile":      kindString,
+	"name":              kindString,
+	"owner":             kindString,
+	"tags":              kindMap,
+	"volumesize":        kindInt,
+	"profilearn":        kindString,
+	"execcommand":       kindArgs,
+	"arch":              kindString,
+	"distro":            kindString,
+	"count":             kindInt,
+	"cluster":           kindString,
+	"group":             kindString,
+	"taskrole":          kindString,
+	"family":            kindString,
+	"execrole":          kindString,
+	"cpu":               kindString,
+	"memory":            kindString,
+	"image":             kindString,
+	"nics":              kindList,
+	"force":             kindBool,
+	"response":          kindString,
+	"format":            kindString,
+	"since":             kindString,
+	"until":             kindString,
+	"resource":          kindString,
+	"pattern":           kindString,
+	"async":             kindBool,
+	"jobid":             kindString,
+	"idempotencykey":    kindString,
+	"template":          kindJSON,
+	"params":            kindMap,
+	"columns":           kindList,
+}
@@ -0,0 +1,138 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+
+//go:build ignore
+
+// genfields writes fields.go from the PostRequest of the toolbox.
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	srcDir = "../.."
+	output = "fields.go"
+)
+
+// fields which are not given as --field value
+var skipFields = map[string]bool{
+	"command":  true,
+	"role":     true, // roleFields
+	"requests": true, // ltb batch
+}
+
+// fields which take one element per flag
+var argsFields = map[string]bool{
+	"execcommand": true,
+}
+
+func main() {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, srcDir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		fatal(err)
+	}
+	pkg, ok := pkgs["main"]
+	if !ok {
+		fatal(fmt.Errorf("no package main in %s", srcDir))
+	}
+	types := map[string]ast.Expr{}
+	for _, f := range pkg.Files {
+		for _, decl := range f.Decls {
+			gd, ok := decl.(*ast.GenDecl)
+			if !ok || gd.Tok != token.TYPE {
+				continue
+			}
+			for _, spec := range gd.Specs {
+				ts := spec.(*ast.TypeSpec)
+				types[ts.Name.Name] = ts.Type
+			}
+		}
+	}
+	st, ok := types["PostRequest"].(*ast.StructType)
+	if !ok {
+		fatal(fmt.Errorf("no PostRequest"))
+	}
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "// Code generated by genfields.go; DO NOT EDIT.")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "package main")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "// request fields of the toolbox by json name")
+	fmt.Fprintln(&b, "var fieldKinds = map[string]fieldKind{")
+	for _, field := range st.Fields.List {
+		if field.Tag == nil || len(field.Names) == 0 || !field.Names[0].IsExported() {
+			continue
+		}
+		tag, err := strconv.Unquote(field.Tag.Value)
+		if err != nil {
+			fatal(err)
+		}
+		name := strings.Split(reflect.StructTag(tag).Get("json"), ",")[0]
+		if name == "" || name == "-" || skipFields[name] {
+			continue
+		}
+		kind := "kindArgs"
+		if !argsFields[name] {
+			kind, err = kindOf(field.Type, types)
+			if err != nil {
+				fatal(fmt.Errorf("%s: %v", name, err))
+			}
+		}
+		fmt.Fprintf(&b, "\t%q: %s,\n", name, kind)
+	}
+	fmt.Fprintln(&b, "}")
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		fatal(err)
+	}
+	if err := os.WriteFile(output, src, 0644); err != nil {
+		fatal(err)
+	}
+}
+
+func kindOf(expr ast.Expr, types map[string]ast.Expr) (string, error) {
+	switch t := expr.(type) {
+	case *ast.StarExpr:
+		return kindOf(t.X, types)
+	case *ast.Ident:
+		switch t.Name {
+		case "string":
+			return "kindString", nil
+		case "bool":
+			return "kindBool", nil
+		case "int", "int32", "int64":
+			return "kindInt", nil
+		}
+		if def, ok := types[t.Name]; ok {
+			return kindOf(def, types)
+		}
+	case *ast.ArrayType:
+		if id, ok := t.Elt.(*ast.Ident); ok && id.Name == "string" {
+			return "kindList", nil
+		}
+	case *ast.MapType:
+		return "kindMap", nil
+	case *ast.SelectorExpr:
+		if x, ok := t.X.(*ast.Ident); ok && x.Name == "json" && t.Sel.Name == "RawMessage" {
+			return "kindJSON", nil
+		}
+	}
+	return "", fmt.Errorf("unsupported type %T", expr)
+}
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "genfields: %v\n", err)
+	os.Exit(1)
+}
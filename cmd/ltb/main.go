@@ -0,0 +1,136 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+
+// ltb is the command line client of lambda-toolbox.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const usage = `usage: ltb [options] <command>
+
+commands:
+  <service> <command> [--field value]...  run a toolbox command like "ltb ec2 instances --vpc vpc-0123"
+  help [service]                          list the toolbox commands
+  batch <file|->                          run a batch file, {"requests":[...]} or a list of requests
+  upload [-s3] [-chunk bytes] <file>...   upload files into /tmp or tmp/ in the bucket
+  deploy [-chunk bytes] <function> <zip>  upload the zip and update the function code
+  fields                                  list the request fields
+
+The URL and the signing secret and key are taken from LTB_URL, LTB_SECRET and LTB_KEY.
+
+options:
+`
+
+func main() {
+	url := flag.String("url", os.Getenv("LTB_URL"), "toolbox function URL")
+	format := flag.String("o", "table", "output format: table, json or text")
+	timeout := flag.Duration("timeout", 15*time.Minute, "request timeout")
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	args := flag.Args()
+	if len(args) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if args[0] == "fields" {
+		for _, name := range fieldNames() {
+			fmt.Println(name)
+		}
+		return
+	}
+	if *url == "" {
+		fmt.Fprintln(os.Stderr, "no URL, use -url or LTB_URL")
+		os.Exit(2)
+	}
+	c := NewClient(*url, *timeout)
+	c.Secret = os.Getenv("LTB_SECRET")
+	c.Key = os.Getenv("LTB_KEY")
+	resp, err := run(c, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "ltb: %v\n", err)
+		os.Exit(1)
+	}
+	if err := Render(os.Stdout, resp, *format); err != nil {
+		fmt.Fprintf(os.Stderr, "ltb: %v\n", err)
+		os.Exit(1)
+	}
+	if resp.Code != 200 {
+		os.Exit(1)
+	}
+}
+
+func run(c *Client, args []string) (*Response, error) {
+	switch args[0] {
+	case "batch":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("usage: ltb batch <file|->")
+		}
+		return runBatch(c, args[1])
+	case "upload":
+		fs := flag.NewFlagSet("upload", flag.ExitOnError)
+		s3 := fs.Bool("s3", false, "upload into tmp/ in the bucket")
+		chunk := fs.Int("chunk", defaultChunkSize, "chunk size in bytes")
+		fs.Parse(args[1:])
+		if fs.NArg() == 0 {
+			return nil, fmt.Errorf("usage: ltb upload [-s3] [-chunk bytes] <file>...")
+		}
+		reqs := []interface{}{}
+		for _, path := range fs.Args() {
+			req, err := c.uploadFile(path, *s3, *chunk)
+			if err != nil {
+				return nil, err
+			}
+			reqs = append(reqs, req)
+		}
+		return c.Do(map[string]interface{}{"requests": reqs})
+	case "deploy":
+		fs := flag.NewFlagSet("deploy", flag.ExitOnError)
+		chunk := fs.Int("chunk", defaultChunkSize, "chunk size in bytes")
+		fs.Parse(args[1:])
+		if fs.NArg() != 2 {
+			return nil, fmt.Errorf("usage: ltb deploy [-chunk bytes] <function> <zip>")
+		}
+		return c.Deploy(fs.Arg(0), fs.Arg(1), *chunk)
+	}
+	// the leading words are the dotted command
+	n := 0
+	for n < len(args) && !strings.HasPrefix(args[n], "--") {
+		n++
+	}
+	req, err := parseRequest(strings.Join(args[:n], "."), args[n:])
+	if err != nil {
+		return nil, err
+	}
+	return c.Do(req)
+}
+
+func runBatch(c *Client, path string) (*Response, error) {
+	var body []byte
+	var err error
+	if path == "-" {
+		body, err = io.ReadAll(os.Stdin)
+	} else {
+		body, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	var req interface{}
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if list, ok := req.([]interface{}); ok {
+		req = map[string]interface{}{"requests": list}
+	}
+	return c.Do(req)
+}
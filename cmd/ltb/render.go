@@ -0,0 +1,151 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Render writes the response in the format json, text or table
+func Render(w io.Writer, resp *Response, format string) error {
+	switch format {
+	case "json":
+		out := &bytes.Buffer{}
+		if err := json.Indent(out, resp.raw, "", "  "); err != nil {
+			return err
+		}
+		fmt.Fprintln(w, out.String())
+	case "text":
+		for _, line := range resp.Logs {
+			fmt.Fprintln(w, line)
+		}
+	case "table":
+		renderTable(w, resp)
+	default:
+		return fmt.Errorf("unknown format %s", format)
+	}
+	return nil
+}
+
+func renderTable(w io.Writer, resp *Response) {
+	for _, res := range resp.Results {
+		title := res.Command
+		if res.Id != "" {
+			title += " (" + res.Id + ")"
+		}
+		status := res.Status
+		if res.DryRun {
+			status += " dry-run"
+		}
+		fmt.Fprintf(w, "== %s: %s\n", title, status)
+		if res.Error != "" {
+			fmt.Fprintf(w, "error: %s\n", res.Error)
+		}
+		if res.Data == nil {
+			for _, line := range res.Logs {
+				fmt.Fprintln(w, line)
+			}
+			continue
+		}
+		renderData(w, res.Data)
+	}
+	for _, e := range resp.Errors {
+		fmt.Fprintf(w, "error: %s\n", e)
+	}
+	fmt.Fprintf(w, "%s\n", resp.Summary)
+}
+
+// renderData shows a list of objects as a table with the keys as columns
+func renderData(w io.Writer, data interface{}) {
+	rows := []map[string]interface{}{}
+	switch v := data.(type) {
+	case []interface{}:
+		for _, e := range v {
+			m, ok := e.(map[string]interface{})
+			if !ok {
+				fmt.Fprintln(w, cell(e))
+				continue
+			}
+			rows = append(rows, m)
+		}
+	case map[string]interface{}:
+		rows = append(rows, v)
+	default:
+		fmt.Fprintln(w, cell(v))
+		return
+	}
+	if len(rows) == 0 {
+		return
+	}
+	columns := []string{}
+	seen := map[string]bool{}
+	for _, row := range rows {
+		for k := range row {
+			if !seen[k] {
+				seen[k] = true
+				columns = append(columns, k)
+			}
+		}
+	}
+	sort.Slice(columns, func(i, j int) bool {
+		pi, pj := columnOrder(columns[i]), columnOrder(columns[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return columns[i] < columns[j]
+	})
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, row := range rows {
+		cells := []string{}
+		for _, c := range columns {
+			cells = append(cells, cell(row[c]))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	tw.Flush()
+}
+
+// identifying columns come first
+var leadingColumns = []string{"account", "region", "name", "id"}
+
+func columnOrder(c string) int {
+	for i, lc := range leadingColumns {
+		if c == lc {
+			return i
+		}
+	}
+	return len(leadingColumns)
+}
+
+func cell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "-"
+	case string:
+		return v
+	case []interface{}:
+		a := []string{}
+		for _, e := range v {
+			a = append(a, cell(e))
+		}
+		return strings.Join(a, ",")
+	case map[string]interface{}:
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		a := []string{}
+		for _, k := range keys {
+			a = append(a, k+"="+cell(v[k]))
+		}
+		return strings.Join(a, ",")
+	}
+	return fmt.Sprintf("%v", v)
+}
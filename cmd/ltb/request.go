@@ -0,0 +1,145 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:generate go run genfields.go
+
+type fieldKind int
+
+const (
+	kindString fieldKind = iota
+	kindList             // comma separated or repeated
+	kindArgs             // repeated, one element per flag
+	kindBool
+	kindInt
+	kindMap  // k=v,k=v
+	kindJSON // a JSON value
+)
+
+// short names of fields
+var fieldAliases = map[string]string{
+	"vpc":       "vpcid",
+	"instance":  "instanceid",
+	"instances": "instanceids",
+	"subnet":    "subnetid",
+	"volume":    "volumeid",
+	"sgs":       "securitygroupids",
+}
+
+// role is an object in the request
+var roleFields = map[string]string{
+	"role":        "arn",
+	"externalid":  "externalid",
+	"sessionname": "sessionname",
+}
+
+// parseRequest builds the request of the command from --field value arguments
+func parseRequest(command string, args []string) (map[string]interface{}, error) {
+	req := map[string]interface{}{"command": command}
+	role := map[string]interface{}{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "--") {
+			return nil, fmt.Errorf("unexpected argument %s", arg)
+		}
+		name := strings.TrimPrefix(arg, "--")
+		val, hasVal := "", false
+		if a := strings.SplitN(name, "=", 2); len(a) == 2 {
+			name, val, hasVal = a[0], a[1], true
+		}
+		if alias, ok := fieldAliases[name]; ok {
+			name = alias
+		}
+		if key, ok := roleFields[name]; ok {
+			if !hasVal {
+				if i+1 >= len(args) {
+					return nil, fmt.Errorf("--%s needs a value", name)
+				}
+				i++
+				val = args[i]
+			}
+			role[key] = val
+			continue
+		}
+		kind, ok := fieldKinds[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown field --%s", name)
+		}
+		if kind == kindBool && !hasVal {
+			req[name] = true
+			continue
+		}
+		if !hasVal {
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("--%s needs a value", name)
+			}
+			i++
+			val = args[i]
+		}
+		v, err := parseValue(kind, val, req[name])
+		if err != nil {
+			return nil, fmt.Errorf("--%s: %v", name, err)
+		}
+		req[name] = v
+	}
+	if len(role) > 0 {
+		req["role"] = role
+	}
+	return req, nil
+}
+
+func parseValue(kind fieldKind, val string, prev interface{}) (interface{}, error) {
+	switch kind {
+	case kindBool:
+		return strconv.ParseBool(val)
+	case kindInt:
+		return strconv.Atoi(val)
+	case kindList:
+		list, _ := prev.([]string)
+		for _, v := range strings.Split(val, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				list = append(list, v)
+			}
+		}
+		return list, nil
+	case kindArgs:
+		list, _ := prev.([]string)
+		return append(list, val), nil
+	case kindMap:
+		m, _ := prev.(map[string]string)
+		if m == nil {
+			m = map[string]string{}
+		}
+		for _, kv := range strings.Split(val, ",") {
+			a := strings.SplitN(kv, "=", 2)
+			if len(a) != 2 {
+				return nil, fmt.Errorf("bad key=value %s", kv)
+			}
+			m[a[0]] = a[1]
+		}
+		return m, nil
+	case kindJSON:
+		var v interface{}
+		if err := json.Unmarshal([]byte(val), &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	}
+	return val, nil
+}
+
+func fieldNames() []string {
+	names := []string{}
+	for name := range fieldKinds {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,228 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Command is a toolbox command.
+// A required entry "a|b" means one of a or b.
+type Command struct {
+	Name        string
+	Description string
+	Required    []string
+	Optional    []string
+	Handler     func(*Session, PostRequest)
+	// Rollback returns the request which undoes a succeeded result
+	Rollback func(*Result) *PostRequest
+	// Targets returns the ids of the EC2 resources the request changes
+	Targets func(PostRequest) []string
+	// Mutating commands are not run in dry-run mode
+	Mutating bool
+	// Fanout commands can run in several accounts and regions at once
+	Fanout bool
+	// Sensitive commands have secrets in the result which are not recorded
+	Sensitive bool
+	// NativeDryRun commands are run in dry-run mode and check it by the API
+	NativeDryRun bool
+}
+
+var commands = map[string]*Command{}
+
+// fields accepted by every command
+var commonFields = []string{"command", "id", "if", "response", "format", "columns", "dryrun", "region", "profile", "role"}
+
+// fields accepted by the fanout commands
+var fanoutFields = []string{"fanout", "accounts", "regions", "concurrency"}
+
+// a request without command
+var batchCommand = &Command{
+	Name:     "batch",
+	Required: []string{"requests"},
+	Optional: []string{"onerror", "parallel", "concurrency"},
+}
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name:        "help",
+			Description: "list commands, use help.<service> to filter",
+			Handler:     (*Session).doHelp,
+		},
+	)
+}
+
+func RegisterCommands(cmds ...*Command) {
+	for _, cmd := range cmds {
+		commands[cmd.Name] = cmd
+	}
+}
+
+// LookupCommand finds the longest registered name in the dotted command
+// and returns the rest as arguments
+func LookupCommand(command string) (*Command, []string) {
+	a := strings.Split(command, ".")
+	for n := len(a); n > 0; n-- {
+		if cmd, ok := commands[strings.Join(a[:n], ".")]; ok {
+			return cmd, a[n:]
+		}
+	}
+	return nil, nil
+}
+
+// requestFields returns the json names of the fields which are set in the request
+func requestFields(req PostRequest) map[string]bool {
+	fields := map[string]bool{}
+	v := reflect.ValueOf(req)
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+		if !v.Field(i).IsZero() {
+			fields[name] = true
+		}
+	}
+	return fields
+}
+
+func (cmd *Command) Accepts() string {
+	required := "none"
+	if len(cmd.Required) > 0 {
+		required = strings.Join(cmd.Required, ", ")
+	}
+	optional := "none"
+	if len(cmd.Optional) > 0 {
+		optional = strings.Join(cmd.Optional, ", ")
+	}
+	return fmt.Sprintf("required: %s; optional: %s", required, optional)
+}
+
+// HasField reports the command takes the field
+func (cmd *Command) HasField(name string) bool {
+	for _, f := range cmd.Optional {
+		if f == name {
+			return true
+		}
+	}
+	for _, r := range cmd.Required {
+		for _, f := range strings.Split(r, "|") {
+			if f == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Validate checks the required fields are set and no other fields are used
+func (cmd *Command) Validate(req PostRequest) error {
+	fields := requestFields(req)
+	accepted := map[string]bool{}
+	for _, f := range commonFields {
+		accepted[f] = true
+	}
+	for _, f := range cmd.Optional {
+		accepted[f] = true
+	}
+	if cmd.Fanout {
+		for _, f := range fanoutFields {
+			accepted[f] = true
+		}
+	}
+	if cmd.Mutating {
+		accepted["idempotencykey"] = true
+	}
+	missing := []string{}
+	for _, r := range cmd.Required {
+		found := false
+		for _, f := range strings.Split(r, "|") {
+			accepted[f] = true
+			if fields[f] {
+				found = true
+			}
+		}
+		if !found {
+			missing = append(missing, r)
+		}
+	}
+	unknown := []string{}
+	for f := range fields {
+		if !accepted[f] {
+			unknown = append(unknown, f)
+		}
+	}
+	switch req.Response {
+	case "", "text", "json":
+	default:
+		return fmt.Errorf("%s: unknown response %s (text or json)", cmd.Name, req.Response)
+	}
+	if req.Role != nil && req.Role.ARN == "" {
+		return fmt.Errorf("%s: role has no arn", cmd.Name)
+	}
+	if err := checkFormat(req.Format, req.Columns); err != nil {
+		return fmt.Errorf("%s: %v", cmd.Name, err)
+	}
+	if len(missing) == 0 && len(unknown) == 0 {
+		return nil
+	}
+	msgs := []string{}
+	if len(missing) > 0 {
+		msgs = append(msgs, "missing "+strings.Join(missing, ", "))
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		msgs = append(msgs, "not accepted "+strings.Join(unknown, ", "))
+	}
+	return fmt.Errorf("%s: %s (%s)", cmd.Name, strings.Join(msgs, "; "), cmd.Accepts())
+}
+
+type CommandHelp struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Required    []string `json:"required"`
+	Optional    []string `json:"optional"`
+}
+
+func (h CommandHelp) String() string {
+	cmd := Command{Required: h.Required, Optional: h.Optional}
+	return fmt.Sprintf("%s: %s (%s)", h.Name, h.Description, cmd.Accepts())
+}
+
+func (s *Session) doHelp(req PostRequest) {
+	prefix := ""
+	if len(req.args) > 0 {
+		prefix = strings.Join(req.args, ".") + "."
+	}
+	names := []string{}
+	for name := range commands {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		s.Failf(http.StatusNotFound, "no commands for %s", strings.Join(req.args, "."))
+		return
+	}
+	sort.Strings(names)
+	data := []CommandHelp{}
+	for _, name := range names {
+		cmd := commands[name]
+		data = append(data, CommandHelp{
+			Name:        cmd.Name,
+			Description: cmd.Description,
+			Required:    cmd.Required,
+			Optional:    cmd.Optional,
+		})
+	}
+	s.Items(data)
+}
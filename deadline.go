@@ -0,0 +1,63 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// time left to return the partial output before Lambda stops the function
+const deadlineMargin = 3 * time.Second
+
+// withDeadlineMargin returns the context which is done shortly before the invocation deadline
+func withDeadlineMargin(ctx context.Context) (context.Context, context.CancelFunc) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, deadline.Add(-deadlineMargin))
+}
+
+// expired reports the session has no time to run more steps
+func (s *Session) expired() bool {
+	return s.ctx.Err() != nil
+}
+
+// sleep waits for d and returns false when the session expired
+func (s *Session) sleep(d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
+// timedOut records the n-th step of the session as the step which timed out
+func (s *Session) timedOut(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.timeoutStep == 0 {
+		s.timeoutStep = n
+	}
+}
+
+// checkTimeout adds the timeout marker to the output
+func (s *Session) checkTimeout() {
+	if s.timeoutStep == 0 {
+		return
+	}
+	out := fmt.Sprintf("timed out at step %d", s.timeoutStep)
+	code := http.StatusGatewayTimeout
+	if s.cancelled {
+		out = fmt.Sprintf("cancelled at step %d", s.timeoutStep)
+		code = http.StatusConflict
+	}
+	s.Logf("%s", out)
+	s.Errors = append(s.Errors, out)
+	s.code = code
+}
@@ -3,27 +3,33 @@ package main
 
 import (
 	"context"
+	"errors"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/smithy-go"
 )
 
 type EC2Client struct {
 	InstanceIds []string
 	VpcId       *string
+	DryRun      bool
+	ctx         context.Context
 	client      *ec2.Client
 }
 
-func NewEC2Client() (*EC2Client, error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		return nil, err
-	}
-	client := &EC2Client{
+func NewEC2Client(ctx context.Context, cfg aws.Config) *EC2Client {
+	return &EC2Client{
+		ctx:    ctx,
 		client: ec2.NewFromConfig(cfg),
 	}
-	return client, nil
+}
+
+// IsDryRunError reports the error means the request would have succeeded
+func IsDryRunError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "DryRunOperation"
 }
 
 func (cli *EC2Client) CreateTags(id string, kvs map[string]string) error {
@@ -40,10 +46,11 @@ func (cli *EC2Client) CreateTags(id string, kvs map[string]string) error {
 		tags = append(tags, tag(k, v))
 	}
 	input := &ec2.CreateTagsInput{
+		DryRun:    &cli.DryRun,
 		Resources: []string{id},
 		Tags:      tags,
 	}
-	_, err := cli.client.CreateTags(context.TODO(), input)
+	_, err := cli.client.CreateTags(cli.ctx, input)
 	return err
 }
 
@@ -60,7 +67,7 @@ func (cli *EC2Client) DescribeInstances() ([]types.Instance, error) {
 		}
 		input.Filters = append(input.Filters, filter)
 	}
-	output, err := cli.client.DescribeInstances(context.TODO(), input)
+	output, err := cli.client.DescribeInstances(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -71,9 +78,43 @@ func (cli *EC2Client) DescribeInstances() ([]types.Instance, error) {
 	return instances, nil
 }
 
+// DescribeTags returns the tags of the resources by id
+func (cli *EC2Client) DescribeTags(ids []string) (map[string]map[string]string, error) {
+	fname := "resource-id"
+	input := &ec2.DescribeTagsInput{
+		Filters: []types.Filter{
+			{
+				Name:   &fname,
+				Values: ids,
+			},
+		},
+	}
+	tags := map[string]map[string]string{}
+	for _, id := range ids {
+		tags[id] = map[string]string{}
+	}
+	paginator := ec2.NewDescribeTagsPaginator(cli.client, input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(cli.ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range output.Tags {
+			if t.ResourceId == nil || t.Key == nil {
+				continue
+			}
+			if _, ok := tags[*t.ResourceId]; !ok {
+				tags[*t.ResourceId] = map[string]string{}
+			}
+			tags[*t.ResourceId][*t.Key] = strval(t.Value)
+		}
+	}
+	return tags, nil
+}
+
 func (cli *EC2Client) DescribeVpcs() ([]types.Vpc, error) {
 	input := &ec2.DescribeVpcsInput{}
-	output, err := cli.client.DescribeVpcs(context.TODO(), input)
+	output, err := cli.client.DescribeVpcs(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -90,7 +131,7 @@ func (cli *EC2Client) DescribeSubnets() ([]types.Subnet, error) {
 		}
 		input.Filters = append(input.Filters, filter)
 	}
-	output, err := cli.client.DescribeSubnets(context.TODO(), input)
+	output, err := cli.client.DescribeSubnets(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -107,7 +148,7 @@ func (cli *EC2Client) DescribeSecurityGroups() ([]types.SecurityGroup, error) {
 		}
 		input.Filters = append(input.Filters, filter)
 	}
-	output, err := cli.client.DescribeSecurityGroups(context.TODO(), input)
+	output, err := cli.client.DescribeSecurityGroups(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -126,7 +167,7 @@ func (cli *EC2Client) DescribeNetworkInterfaces(nics []string) ([]types.NetworkI
 		}
 		input.Filters = append(input.Filters, filter)
 	}
-	output, err := cli.client.DescribeNetworkInterfaces(context.TODO(), input)
+	output, err := cli.client.DescribeNetworkInterfaces(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -135,7 +176,7 @@ func (cli *EC2Client) DescribeNetworkInterfaces(nics []string) ([]types.NetworkI
 
 func (cli *EC2Client) DescribeVolumes() ([]types.Volume, error) {
 	input := &ec2.DescribeVolumesInput{}
-	output, err := cli.client.DescribeVolumes(context.TODO(), input)
+	output, err := cli.client.DescribeVolumes(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -144,11 +185,12 @@ func (cli *EC2Client) DescribeVolumes() ([]types.Volume, error) {
 
 func (cli *EC2Client) CreateVolume(az string, sz int32) (string, error) {
 	input := &ec2.CreateVolumeInput{
+		DryRun:           &cli.DryRun,
 		AvailabilityZone: &az,
 		Size:             &sz,
 		VolumeType:       "gp3",
 	}
-	output, err := cli.client.CreateVolume(context.TODO(), input)
+	output, err := cli.client.CreateVolume(cli.ctx, input)
 	if err != nil {
 		return "", err
 	}
@@ -157,9 +199,10 @@ func (cli *EC2Client) CreateVolume(az string, sz int32) (string, error) {
 
 func (cli *EC2Client) DeleteVolume(volumeid string) error {
 	input := &ec2.DeleteVolumeInput{
+		DryRun:   &cli.DryRun,
 		VolumeId: &volumeid,
 	}
-	_, err := cli.client.DeleteVolume(context.TODO(), input)
+	_, err := cli.client.DeleteVolume(cli.ctx, input)
 	if err != nil {
 		return err
 	}
@@ -168,11 +211,12 @@ func (cli *EC2Client) DeleteVolume(volumeid string) error {
 
 func (cli *EC2Client) AttachVolume(volumeId, instanceId, device string) error {
 	input := &ec2.AttachVolumeInput{
+		DryRun:     &cli.DryRun,
 		Device:     &device,
 		InstanceId: &instanceId,
 		VolumeId:   &volumeId,
 	}
-	_, err := cli.client.AttachVolume(context.TODO(), input)
+	_, err := cli.client.AttachVolume(cli.ctx, input)
 	if err != nil {
 		return err
 	}
@@ -181,9 +225,10 @@ func (cli *EC2Client) AttachVolume(volumeId, instanceId, device string) error {
 
 func (cli *EC2Client) DetachVolume(volumeId string) error {
 	input := &ec2.DetachVolumeInput{
+		DryRun:   &cli.DryRun,
 		VolumeId: &volumeId,
 	}
-	_, err := cli.client.DetachVolume(context.TODO(), input)
+	_, err := cli.client.DetachVolume(cli.ctx, input)
 	if err != nil {
 		return err
 	}
@@ -220,8 +265,10 @@ func (cli *EC2Client) RequestSpotInstances(count int32, ec2spec *EC2InstanceSpec
 		tag("lambda-toolbox", "yes"),
 	}
 	input := &ec2.RequestSpotInstancesInput{
+		DryRun:              &cli.DryRun,
 		InstanceCount:       &count,
 		LaunchSpecification: spec,
+		ClientToken:         ec2spec.ClientToken,
 		TagSpecifications: []types.TagSpecification{
 			types.TagSpecification{
 				ResourceType: types.ResourceTypeSpotInstancesRequest,
@@ -229,7 +276,7 @@ func (cli *EC2Client) RequestSpotInstances(count int32, ec2spec *EC2InstanceSpec
 			},
 		},
 	}
-	output, err := cli.client.RequestSpotInstances(context.TODO(), input)
+	output, err := cli.client.RequestSpotInstances(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -240,7 +287,7 @@ func (cli *EC2Client) DescribeSpotInstanceRequests(ids []string) ([]types.SpotIn
 	input := &ec2.DescribeSpotInstanceRequestsInput{
 		SpotInstanceRequestIds: ids,
 	}
-	output, err := cli.client.DescribeSpotInstanceRequests(context.TODO(), input)
+	output, err := cli.client.DescribeSpotInstanceRequests(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -258,7 +305,7 @@ func (cli *EC2Client) DescribeImages(owner, arch, name string) ([]types.Image, e
 			filter("name", name),
 		},
 	}
-	output, err := cli.client.DescribeImages(context.TODO(), input)
+	output, err := cli.client.DescribeImages(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -267,9 +314,10 @@ func (cli *EC2Client) DescribeImages(owner, arch, name string) ([]types.Image, e
 
 func (cli *EC2Client) StartInstances(ids []string) ([]types.InstanceStateChange, error) {
 	input := &ec2.StartInstancesInput{
+		DryRun:      &cli.DryRun,
 		InstanceIds: ids,
 	}
-	output, err := cli.client.StartInstances(context.TODO(), input)
+	output, err := cli.client.StartInstances(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -278,10 +326,11 @@ func (cli *EC2Client) StartInstances(ids []string) ([]types.InstanceStateChange,
 
 func (cli *EC2Client) StopInstances(ids []string, force *bool) ([]types.InstanceStateChange, error) {
 	input := &ec2.StopInstancesInput{
+		DryRun:      &cli.DryRun,
 		InstanceIds: ids,
 		Force:       force,
 	}
-	output, err := cli.client.StopInstances(context.TODO(), input)
+	output, err := cli.client.StopInstances(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -290,9 +339,10 @@ func (cli *EC2Client) StopInstances(ids []string, force *bool) ([]types.Instance
 
 func (cli *EC2Client) TerminateInstances(ids []string) ([]types.InstanceStateChange, error) {
 	input := &ec2.TerminateInstancesInput{
+		DryRun:      &cli.DryRun,
 		InstanceIds: ids,
 	}
-	output, err := cli.client.TerminateInstances(context.TODO(), input)
+	output, err := cli.client.TerminateInstances(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -303,6 +353,7 @@ func (cli *EC2Client) RunInstances(count int32, ec2spec *EC2InstanceSpec) ([]typ
 	netspecs, securitygroupids := getNetworkInterfaceSpecification(ec2spec)
 	ebsoptimized := true
 	input := &ec2.RunInstancesInput{
+		DryRun:              &cli.DryRun,
 		MaxCount:            &count,
 		MinCount:            &count,
 		BlockDeviceMappings: EC2BlockDeviceMappings(ec2spec.VolumeSize, "gp3"),
@@ -313,13 +364,14 @@ func (cli *EC2Client) RunInstances(count int32, ec2spec *EC2InstanceSpec) ([]typ
 		SecurityGroupIds:    securitygroupids,
 		NetworkInterfaces:   netspecs,
 		UserData:            ec2spec.UserData,
+		ClientToken:         ec2spec.ClientToken,
 	}
 	if ec2spec.ProfileArn != nil {
 		input.IamInstanceProfile = &types.IamInstanceProfileSpecification{
 			Arn: ec2spec.ProfileArn,
 		}
 	}
-	output, err := cli.client.RunInstances(context.TODO(), input)
+	output, err := cli.client.RunInstances(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -328,12 +380,13 @@ func (cli *EC2Client) RunInstances(count int32, ec2spec *EC2InstanceSpec) ([]typ
 
 func (cli *EC2Client) ModifyInstanceAttributeType(instanceid, instancetype string) error {
 	input := &ec2.ModifyInstanceAttributeInput{
+		DryRun:     &cli.DryRun,
 		InstanceId: &instanceid,
 		InstanceType: &types.AttributeValue{
 			Value: &instancetype,
 		},
 	}
-	_, err := cli.client.ModifyInstanceAttribute(context.TODO(), input)
+	_, err := cli.client.ModifyInstanceAttribute(cli.ctx, input)
 	return err
 }
 
@@ -0,0 +1,754 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+var ec2InstanceSpecFields = []string{
+	"securitygroupids", "instancetype", "keyname", "userdatafile", "subnetid",
+	"associatepublicip", "tags", "volumesize", "profilearn", "count",
+}
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name:        "ec2.vpcs",
+			Description: "describe VPCs",
+			Handler:     withEC2((*Session).doEC2Vpcs),
+		},
+		&Command{
+			Name:        "ec2.subnets",
+			Description: "describe subnets",
+			Optional:    []string{"vpcid"},
+			Handler:     withEC2((*Session).doEC2Subnets),
+		},
+		&Command{
+			Name:        "ec2.sgs",
+			Description: "describe security groups",
+			Optional:    []string{"vpcid"},
+			Handler:     withEC2((*Session).doEC2SecurityGroups),
+		},
+		&Command{
+			Name:        "ec2.nics",
+			Description: "describe network interfaces",
+			Optional:    []string{"vpcid", "nics"},
+			Handler:     withEC2((*Session).doEC2NetworkInterfaces),
+		},
+		&Command{
+			Name:        "ec2.vols",
+			Description: "describe EBS volumes",
+			Handler:     withEC2((*Session).doEC2Volumes),
+			Fanout:      true,
+		},
+		&Command{
+			Name:        "ec2.images",
+			Description: "find the latest image by name and owner or by distro",
+			Optional:    []string{"arch", "name", "owner", "distro"},
+			Handler:     withEC2((*Session).doEC2Images),
+		},
+		&Command{
+			Name:        "ec2.instances",
+			Description: "describe instances",
+			Optional:    []string{"vpcid"},
+			Handler:     withEC2((*Session).doEC2Instances),
+			Fanout:      true,
+		},
+		&Command{
+			Name:        "ec2.describe",
+			Description: "same as ec2.instances",
+			Optional:    []string{"vpcid"},
+			Handler:     withEC2((*Session).doEC2Instances),
+			Fanout:      true,
+		},
+		&Command{
+			Name:         "ec2.spotrequest",
+			Description:  "request spot instances and wait for fulfillment",
+			Required:     []string{"imageid", "name"},
+			Optional:     ec2InstanceSpecFields,
+			Handler:      withEC2((*Session).doEC2RequestSpotInstances),
+			Rollback:     rollbackEC2Instances,
+			Mutating:     true,
+			NativeDryRun: true,
+		},
+		&Command{
+			Name:         "ec2.run",
+			Description:  "launch instances",
+			Required:     []string{"imageid", "name"},
+			Optional:     ec2InstanceSpecFields,
+			Handler:      withEC2((*Session).doEC2RunInstances),
+			Rollback:     rollbackEC2Instances,
+			Mutating:     true,
+			NativeDryRun: true,
+		},
+		&Command{
+			Name:         "ec2.start",
+			Description:  "start instances",
+			Required:     []string{"instanceid|instanceids"},
+			Handler:      withEC2((*Session).doEC2Start),
+			Rollback:     rollbackEC2Start,
+			Targets:      targetEC2Instances,
+			Mutating:     true,
+			NativeDryRun: true,
+		},
+		&Command{
+			Name:         "ec2.stop",
+			Description:  "stop instances",
+			Required:     []string{"instanceid|instanceids"},
+			Optional:     []string{"force"},
+			Handler:      withEC2((*Session).doEC2Stop),
+			Rollback:     rollbackEC2Stop,
+			Targets:      targetEC2Instances,
+			Mutating:     true,
+			NativeDryRun: true,
+		},
+		&Command{
+			Name:         "ec2.terminate",
+			Description:  "terminate instances",
+			Required:     []string{"instanceid|instanceids"},
+			Handler:      withEC2((*Session).doEC2Terminate),
+			Targets:      targetEC2Instances,
+			Mutating:     true,
+			NativeDryRun: true,
+		},
+		&Command{
+			Name:         "ec2.rename",
+			Description:  "change the Name tag of an instance",
+			Required:     []string{"instanceid", "name"},
+			Handler:      withEC2((*Session).doEC2Rename),
+			Rollback:     rollbackEC2Rename,
+			Targets:      targetEC2Instances,
+			Mutating:     true,
+			NativeDryRun: true,
+		},
+		&Command{
+			Name:         "ec2.createvolume",
+			Description:  "create a gp3 volume",
+			Required:     []string{"az", "volumesize"},
+			Optional:     []string{"name"},
+			Handler:      withEC2((*Session).doEC2CreateVolume),
+			Rollback:     rollbackEC2CreateVolume,
+			Mutating:     true,
+			NativeDryRun: true,
+		},
+		&Command{
+			Name:         "ec2.deletevolume",
+			Description:  "delete a volume",
+			Required:     []string{"volumeid"},
+			Handler:      withEC2((*Session).doEC2DeleteVolume),
+			Targets:      targetEC2Volume,
+			Mutating:     true,
+			NativeDryRun: true,
+		},
+		&Command{
+			Name:         "ec2.attachvolume",
+			Description:  "attach a volume to an instance",
+			Required:     []string{"volumeid", "instanceid"},
+			Optional:     []string{"device"},
+			Handler:      withEC2((*Session).doEC2AttachVolume),
+			Rollback:     rollbackEC2AttachVolume,
+			Targets:      targetEC2VolumeAndInstance,
+			Mutating:     true,
+			NativeDryRun: true,
+		},
+		&Command{
+			Name:         "ec2.detachvolume",
+			Description:  "detach a volume",
+			Required:     []string{"volumeid"},
+			Handler:      withEC2((*Session).doEC2DetachVolume),
+			Targets:      targetEC2Volume,
+			Mutating:     true,
+			NativeDryRun: true,
+		},
+		&Command{
+			Name:         "ec2.change",
+			Description:  "change an instance attribute, use ec2.change.type",
+			Required:     []string{"instanceid", "instancetype"},
+			Handler:      withEC2((*Session).doEC2Change),
+			Targets:      targetEC2Instances,
+			Mutating:     true,
+			NativeDryRun: true,
+		},
+	)
+}
+
+func targetEC2Instances(req PostRequest) []string {
+	return parseInstanceIds(req)
+}
+
+func targetEC2Volume(req PostRequest) []string {
+	if req.VolumeId == nil {
+		return nil
+	}
+	return []string{*req.VolumeId}
+}
+
+func targetEC2VolumeAndInstance(req PostRequest) []string {
+	return append(targetEC2Volume(req), targetEC2Instances(req)...)
+}
+
+func rollbackEC2Instances(res *Result) *PostRequest {
+	ids := outputStrings(res, "instanceids")
+	if len(ids) == 0 {
+		return nil
+	}
+	return &PostRequest{Command: "ec2.terminate", InstanceIds: ids}
+}
+
+func rollbackEC2Start(res *Result) *PostRequest {
+	ids := outputStrings(res, "instanceids")
+	if len(ids) == 0 {
+		return nil
+	}
+	return &PostRequest{Command: "ec2.stop", InstanceIds: ids}
+}
+
+func rollbackEC2Stop(res *Result) *PostRequest {
+	ids := outputStrings(res, "instanceids")
+	if len(ids) == 0 {
+		return nil
+	}
+	return &PostRequest{Command: "ec2.start", InstanceIds: ids}
+}
+
+func rollbackEC2Rename(res *Result) *PostRequest {
+	id := outputString(res, "instanceid")
+	prev := outputString(res, "previous")
+	if id == nil || prev == nil || *prev == "" {
+		return nil
+	}
+	return &PostRequest{Command: "ec2.rename", InstanceId: id, Name: prev}
+}
+
+func rollbackEC2CreateVolume(res *Result) *PostRequest {
+	id := outputString(res, "volumeid")
+	if id == nil {
+		return nil
+	}
+	return &PostRequest{Command: "ec2.deletevolume", VolumeId: id}
+}
+
+func rollbackEC2AttachVolume(res *Result) *PostRequest {
+	id := outputString(res, "volumeid")
+	if id == nil {
+		return nil
+	}
+	return &PostRequest{Command: "ec2.detachvolume", VolumeId: id}
+}
+
+func withEC2(f func(*Session, *EC2Client, PostRequest)) func(*Session, PostRequest) {
+	return func(s *Session, req PostRequest) {
+		cfg, err := s.awsConfig(req)
+		if err != nil {
+			s.Failf(http.StatusInternalServerError, "LoadAWSConfig: %v", err)
+			return
+		}
+		cli := NewEC2Client(s.ctx, cfg)
+		cli.DryRun = req.DryRun
+		f(s, cli, req)
+	}
+}
+
+type EC2InstanceSpec struct {
+	ImageId           string
+	SecurityGroupIds  []string
+	InstanceType      string
+	KeyName           *string
+	UserData          *string
+	SubnetId          *string
+	AssociatePublicIp *bool
+	VolumeSize        int32
+	ProfileArn        *string
+	Tags              map[string]string
+	ClientToken       *string
+}
+
+// ec2DryRun reports the dry-run request would have succeeded
+func (s *Session) ec2DryRun(op string, err error) bool {
+	if !IsDryRunError(err) {
+		return false
+	}
+	s.Logf("dry-run: %s would have succeeded", op)
+	return true
+}
+
+func (s *Session) newEC2InstanceSpec(req PostRequest) (*EC2InstanceSpec, error) {
+	var userdata *string = nil
+	if req.UserDataFile != nil {
+		obj, err := s.getFile(*req.UserDataFile)
+		if err != nil {
+			return nil, fmt.Errorf("UserDataFile: %v", err)
+		}
+		data := base64.StdEncoding.EncodeToString(obj)
+		userdata = &data
+	}
+	tags := map[string]string{
+		"lambda-toolbox": "yes",
+		"Name":           *req.Name,
+	}
+	envtag := os.Getenv("TAGS")
+	if envtag != "" {
+		var etags map[string]string
+		if json.Unmarshal([]byte(envtag), &etags) == nil {
+			for k, v := range etags {
+				tags[k] = v
+			}
+		}
+	}
+	for k, v := range req.Tags {
+		tags[k] = v
+	}
+	var volumesize int32 = 8
+	if req.VolumeSize != nil {
+		volumesize = *req.VolumeSize
+	}
+	var token *string
+	if req.IdempotencyKey != "" {
+		// EC2 returns the same instances for the retry
+		t := s.idempotencyToken(req.IdempotencyKey)
+		token = &t
+	}
+	return &EC2InstanceSpec{
+		ImageId:           *req.ImageId,
+		SecurityGroupIds:  req.SecurityGroupIds,
+		InstanceType:      req.InstanceType,
+		KeyName:           req.KeyName,
+		UserData:          userdata,
+		SubnetId:          req.SubnetId,
+		AssociatePublicIp: req.AssociatePublicIp,
+		Tags:              tags,
+		VolumeSize:        volumesize,
+		ProfileArn:        req.ProfileArn,
+		ClientToken:       token,
+	}, nil
+}
+
+func (s *Session) doEC2RunInstances(cli *EC2Client, req PostRequest) {
+	ec2spec, err := s.newEC2InstanceSpec(req)
+	if err != nil {
+		s.Invalidf("newEC2InstanceSpec: %v", err)
+		return
+	}
+	var count int32 = 1
+	if req.Count != nil {
+		count = *req.Count
+	}
+	instances, err := cli.RunInstances(count, ec2spec)
+	if s.ec2DryRun("RunInstances", err) {
+		return
+	}
+	if err != nil {
+		s.Errorf("RunInstances: %v", err)
+		return
+	}
+	data := []EC2InstanceData{}
+	for _, i := range instances {
+		cli.SetTags(i, ec2spec.Tags)
+		data = append(data, NewEC2InstanceData(i))
+	}
+	s.Items(data)
+	s.outputInstances(data)
+}
+
+func (s *Session) doEC2RequestSpotInstances(cli *EC2Client, req PostRequest) {
+	ec2spec, err := s.newEC2InstanceSpec(req)
+	if err != nil {
+		s.Invalidf("newEC2InstanceSpec: %v", err)
+		return
+	}
+	var count int32 = 1
+	if req.Count != nil {
+		count = *req.Count
+	}
+	sirs, err := cli.RequestSpotInstances(count, ec2spec)
+	if s.ec2DryRun("RequestSpotInstances", err) {
+		return
+	}
+	if err != nil {
+		s.Errorf("RequestSpotInstances: %v", err)
+		return
+	}
+	ids := []string{}
+	for _, sir := range sirs {
+		s.Logf("id=%s", *sir.SpotInstanceRequestId)
+		ids = append(ids, *sir.SpotInstanceRequestId)
+	}
+	if !s.sleep(time.Second) {
+		s.Failf(http.StatusGatewayTimeout, "timed out waiting for %s", strings.Join(ids, ","))
+		return
+	}
+	first := true
+	for {
+		sirs, err = cli.DescribeSpotInstanceRequests(ids)
+		if err != nil {
+			if !first {
+				s.Errorf("DescribeSpotInstanceRequests: %v", err)
+				return
+			}
+			s.Logf("DescribeSpotInstanceRequests: %v", err)
+			first = false
+			if !s.sleep(time.Second) {
+				s.Failf(http.StatusGatewayTimeout, "timed out waiting for %s", strings.Join(ids, ","))
+				return
+			}
+			continue
+		}
+		fullfilled := true
+		for _, sir := range sirs {
+			if sir.State == ec2types.SpotInstanceStateOpen {
+				s.Logf("%s is not fullfilled", *sir.SpotInstanceRequestId)
+				fullfilled = false
+			}
+			// active/closed/cancelled/failed
+		}
+		if fullfilled {
+			break
+		}
+		if !s.sleep(time.Second) {
+			s.Failf(http.StatusGatewayTimeout, "timed out waiting for %s", strings.Join(ids, ","))
+			return
+		}
+	}
+	// setup tag
+	cli.InstanceIds = nil
+	cli.VpcId = nil
+	for _, sir := range sirs {
+		if sir.InstanceId != nil {
+			cli.InstanceIds = append(cli.InstanceIds, *sir.InstanceId)
+		}
+	}
+	if len(cli.InstanceIds) == 0 {
+		s.Errorf("no activated instances")
+		return
+	}
+	instances, err := cli.DescribeInstances()
+	if err != nil {
+		s.Logf("DescribeInstances: %v", err)
+		// why?
+		return
+	}
+	// mark spot instance
+	ec2spec.Tags["SpotInstance"] = "yes"
+	data := []EC2InstanceData{}
+	for _, i := range instances {
+		cli.SetTags(i, ec2spec.Tags)
+		data = append(data, NewEC2InstanceData(i))
+	}
+	s.Items(data)
+	s.outputInstances(data)
+}
+
+// outputInstances makes the instance ids available to later steps
+func (s *Session) outputInstances(data []EC2InstanceData) {
+	ids := []string{}
+	for _, d := range data {
+		ids = append(ids, d.InstanceId)
+	}
+	if len(ids) > 0 {
+		s.Output("instanceid", ids[0])
+	}
+	s.Output("instanceids", ids)
+}
+
+func parseInstanceIds(req PostRequest) []string {
+	ids := req.InstanceIds
+	if req.InstanceId != nil {
+		ids = append(ids, *req.InstanceId)
+	}
+	return ids
+}
+
+func (s *Session) showInstancesState(instances []ec2types.InstanceStateChange) {
+	data := []EC2StateChangeData{}
+	ids := []string{}
+	for _, i := range instances {
+		d := NewEC2StateChangeData(i)
+		data = append(data, d)
+		// the rollback must not touch instances which were already in the state
+		if d.PreviousState == d.CurrentState {
+			continue
+		}
+		ids = append(ids, d.InstanceId)
+	}
+	s.Items(data)
+	s.Output("instanceids", ids)
+}
+
+func (s *Session) doEC2Vpcs(cli *EC2Client, req PostRequest) {
+	vpcs, err := cli.DescribeVpcs()
+	if err != nil {
+		s.Errorf("DescribeVpcs: %v", err)
+		return
+	}
+	data := []EC2VpcData{}
+	for _, vpc := range vpcs {
+		data = append(data, NewEC2VpcData(vpc))
+	}
+	s.Items(data)
+}
+
+func (s *Session) doEC2Subnets(cli *EC2Client, req PostRequest) {
+	cli.VpcId = nil
+	if req.VpcId != "" {
+		s.Logf("VpcId: %s", req.VpcId)
+		cli.VpcId = &req.VpcId
+	}
+	subnets, err := cli.DescribeSubnets()
+	if err != nil {
+		s.Errorf("DescribeSubnets: %v", err)
+		return
+	}
+	data := []EC2SubnetData{}
+	for _, subnet := range subnets {
+		data = append(data, NewEC2SubnetData(subnet))
+	}
+	s.Items(data)
+}
+
+func (s *Session) doEC2SecurityGroups(cli *EC2Client, req PostRequest) {
+	cli.VpcId = nil
+	if req.VpcId != "" {
+		s.Logf("VpcId: %s", req.VpcId)
+		cli.VpcId = &req.VpcId
+	}
+	sgs, err := cli.DescribeSecurityGroups()
+	if err != nil {
+		s.Errorf("DescribeSecurityGroups: %v", err)
+		return
+	}
+	data := []EC2SecurityGroupData{}
+	for _, sg := range sgs {
+		data = append(data, NewEC2SecurityGroupData(sg))
+	}
+	s.Items(data)
+}
+
+func (s *Session) doEC2NetworkInterfaces(cli *EC2Client, req PostRequest) {
+	cli.VpcId = nil
+	if req.VpcId != "" {
+		s.Logf("VpcId: %s", req.VpcId)
+		cli.VpcId = &req.VpcId
+	}
+	nics, err := cli.DescribeNetworkInterfaces(req.Nics)
+	if err != nil {
+		s.Errorf("DescribeNetworkInterfaces: %v", err)
+		return
+	}
+	data := []EC2NetworkInterfaceData{}
+	for _, nic := range nics {
+		data = append(data, NewEC2NetworkInterfaceData(nic))
+	}
+	s.Items(data)
+}
+
+func (s *Session) doEC2Volumes(cli *EC2Client, req PostRequest) {
+	vols, err := cli.DescribeVolumes()
+	if err != nil {
+		s.Errorf("DescribeVolumes: %v", err)
+		return
+	}
+	data := []EC2VolumeData{}
+	for _, vol := range vols {
+		data = append(data, NewEC2VolumeData(vol))
+	}
+	s.Items(data)
+}
+
+func (s *Session) doEC2Images(cli *EC2Client, req PostRequest) {
+	arch := "x86_64"
+	if req.Arch != nil {
+		arch = *req.Arch
+	}
+	var image ec2types.Image
+	var err error
+	if req.Name != nil && req.Owner != nil {
+		image, err = cli.GetImage(*req.Name, *req.Owner, arch)
+	} else {
+		distro := "amazon"
+		if req.Distro != nil {
+			distro = *req.Distro
+		}
+		image, err = cli.GetDistroImage(distro, arch)
+	}
+	if err != nil {
+		s.Errorf("GetImage: %v", err)
+		return
+	}
+	s.Items([]EC2ImageData{NewEC2ImageData(image)})
+	s.Output("imageid", *image.ImageId)
+}
+
+func (s *Session) doEC2Instances(cli *EC2Client, req PostRequest) {
+	cli.VpcId = nil
+	if req.VpcId != "" {
+		s.Logf("VpcId: %s", req.VpcId)
+		cli.VpcId = &req.VpcId
+	}
+	instances, err := cli.DescribeInstances()
+	if err != nil {
+		s.Errorf("Describe: %v", err)
+		return
+	}
+	data := []EC2InstanceData{}
+	for _, inst := range instances {
+		data = append(data, NewEC2InstanceData(inst))
+	}
+	s.Items(data)
+	s.outputInstances(data)
+}
+
+func (s *Session) doEC2Start(cli *EC2Client, req PostRequest) {
+	ids := parseInstanceIds(req)
+	instances, err := cli.StartInstances(ids)
+	if s.ec2DryRun("StartInstances", err) {
+		return
+	}
+	if err != nil {
+		s.Errorf("StartInstances: %v", err)
+		return
+	}
+	s.showInstancesState(instances)
+}
+
+func (s *Session) doEC2Stop(cli *EC2Client, req PostRequest) {
+	ids := parseInstanceIds(req)
+	instances, err := cli.StopInstances(ids, req.Force)
+	if s.ec2DryRun("StopInstances", err) {
+		return
+	}
+	if err != nil {
+		s.Errorf("StopInstances: %v", err)
+		return
+	}
+	s.showInstancesState(instances)
+}
+
+func (s *Session) doEC2Terminate(cli *EC2Client, req PostRequest) {
+	ids := parseInstanceIds(req)
+	instances, err := cli.TerminateInstances(ids)
+	if s.ec2DryRun("TerminateInstances", err) {
+		return
+	}
+	if err != nil {
+		s.Errorf("TerminateInstances: %v", err)
+		return
+	}
+	s.showInstancesState(instances)
+}
+
+func (s *Session) doEC2Rename(cli *EC2Client, req PostRequest) {
+	cli.InstanceIds = []string{*req.InstanceId}
+	cli.VpcId = nil
+	instances, err := cli.DescribeInstances()
+	if err != nil {
+		s.Errorf("DescribeInstances: %v", err)
+		return
+	}
+	if len(instances) != 1 {
+		s.Errorf("multiple instances")
+		return
+	}
+	prevname := EC2InstanceName(instances[0])
+	if req.DryRun {
+		s.Logf("dry-run: %s would be renamed from %s to %s", *instances[0].InstanceId, prevname, *req.Name)
+		return
+	}
+	rename := map[string]string{
+		"Name": *req.Name,
+	}
+	cli.SetTags(instances[0], rename)
+	s.Logf("%s: rename %s to %s", *instances[0].InstanceId, prevname, *req.Name)
+	s.SetData(map[string]string{
+		"instanceid": *instances[0].InstanceId,
+		"previous":   prevname,
+		"name":       *req.Name,
+	})
+	s.Output("instanceid", *instances[0].InstanceId)
+	s.Output("previous", prevname)
+}
+
+func (s *Session) doEC2CreateVolume(cli *EC2Client, req PostRequest) {
+	volumeid, err := cli.CreateVolume(*req.AvailabilityZone, *req.VolumeSize)
+	if s.ec2DryRun("CreateVolume", err) {
+		return
+	}
+	if err != nil {
+		s.Errorf("CreateVolume: %v", err)
+		return
+	}
+	s.Logf("Volume %s has been created", volumeid)
+	s.SetData(map[string]string{"volumeid": volumeid})
+	s.Output("volumeid", volumeid)
+	if req.Name != nil {
+		cli.CreateTags(volumeid, map[string]string{"Name": *req.Name})
+	}
+}
+
+func (s *Session) doEC2DeleteVolume(cli *EC2Client, req PostRequest) {
+	err := cli.DeleteVolume(*req.VolumeId)
+	if s.ec2DryRun("DeleteVolume", err) {
+		return
+	}
+	if err != nil {
+		s.Errorf("DeleteVolume: %v", err)
+		return
+	}
+}
+
+func (s *Session) doEC2AttachVolume(cli *EC2Client, req PostRequest) {
+	volumeId := *req.VolumeId
+	instanceId := *req.InstanceId
+	device := "/dev/sdf"
+	if req.Device != nil {
+		device = *req.Device
+	}
+	err := cli.AttachVolume(volumeId, instanceId, device)
+	if s.ec2DryRun("AttachVolume", err) {
+		return
+	}
+	if err != nil {
+		s.Errorf("AttachVolume: %v", err)
+		return
+	}
+	s.Output("volumeid", volumeId)
+}
+
+func (s *Session) doEC2DetachVolume(cli *EC2Client, req PostRequest) {
+	volumeId := *req.VolumeId
+	err := cli.DetachVolume(volumeId)
+	if s.ec2DryRun("DetachVolume", err) {
+		return
+	}
+	if err != nil {
+		s.Errorf("DetachVolume: %v", err)
+		return
+	}
+}
+
+func (s *Session) doEC2Change(cli *EC2Client, req PostRequest) {
+	if len(req.args) == 0 {
+		s.Invalidf("need change attributename")
+		return
+	}
+	if req.args[0] != "type" {
+		s.Invalidf("support only type")
+		return
+	}
+	err := cli.ModifyInstanceAttributeType(*req.InstanceId, req.InstanceType)
+	if s.ec2DryRun("ModifyInstanceAttributeType", err) {
+		return
+	}
+	if err != nil {
+		s.Errorf("ModifyInstanceAttributeType: %v", err)
+		return
+	}
+	s.Logf("instance type has been modified")
+}
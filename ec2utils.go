@@ -30,20 +30,7 @@ func EC2InstanceName(i types.Instance) string {
 	return ""
 }
 
-func EC2InstanceString(i types.Instance) string {
-	name := ""
-	privip := ""
-	pubip := ""
-	if i.PrivateIpAddress != nil {
-		privip = *i.PrivateIpAddress
-	}
-	if i.PublicIpAddress != nil {
-		pubip = *i.PublicIpAddress
-	}
-	tags, namep := EC2GetTagsAndName(i.Tags)
-	if namep != nil {
-		name = *namep
-	}
+func EC2TagList(tags map[string]string) []string {
 	keyval := []string{}
 	for k, v := range tags {
 		keyval = append(keyval, fmt.Sprintf("%s:%s", k, v))
@@ -51,161 +38,281 @@ func EC2InstanceString(i types.Instance) string {
 	sort.Slice(keyval, func(a, b int) bool {
 		return keyval[a] < keyval[b]
 	})
-	return fmt.Sprintf("%s:%s:%s:%s:%s:%s:%v",
-		*i.InstanceId, name, i.InstanceType, i.State.Name, privip, pubip, keyval)
+	return keyval
 }
 
-func EC2VpcString(v types.Vpc) string {
-	tags, namep := EC2GetTagsAndName(v.Tags)
-	name := ""
-	if namep != nil {
-		name = *namep
+func strval(p *string) string {
+	if p == nil {
+		return ""
 	}
-	keyval := []string{}
-	for k, v := range tags {
-		keyval = append(keyval, fmt.Sprintf("%s:%s", k, v))
+	return *p
+}
+
+type EC2InstanceData struct {
+	InstanceId   string            `json:"instanceid"`
+	Name         string            `json:"name"`
+	InstanceType string            `json:"instancetype"`
+	State        string            `json:"state"`
+	PrivateIp    string            `json:"privateip"`
+	PublicIp     string            `json:"publicip"`
+	Tags         map[string]string `json:"tags"`
+}
+
+func NewEC2InstanceData(i types.Instance) EC2InstanceData {
+	tags, namep := EC2GetTagsAndName(i.Tags)
+	d := EC2InstanceData{
+		InstanceId:   *i.InstanceId,
+		Name:         strval(namep),
+		InstanceType: string(i.InstanceType),
+		PrivateIp:    strval(i.PrivateIpAddress),
+		PublicIp:     strval(i.PublicIpAddress),
+		Tags:         tags,
 	}
-	sort.Slice(keyval, func(a, b int) bool {
-		return keyval[a] < keyval[b]
-	})
-	return fmt.Sprintf("%s:%s:%v", *v.VpcId, name, keyval)
+	if i.State != nil {
+		d.State = string(i.State.Name)
+	}
+	return d
+}
+
+func (d EC2InstanceData) String() string {
+	return fmt.Sprintf("%s:%s:%s:%s:%s:%s:%v",
+		d.InstanceId, d.Name, d.InstanceType, d.State, d.PrivateIp, d.PublicIp, EC2TagList(d.Tags))
+}
+
+type EC2VpcData struct {
+	VpcId string            `json:"vpcid"`
+	Name  string            `json:"name"`
+	Cidr  string            `json:"cidr"`
+	Tags  map[string]string `json:"tags"`
 }
 
-func EC2SubnetString(v types.Subnet) string {
+func NewEC2VpcData(v types.Vpc) EC2VpcData {
 	tags, namep := EC2GetTagsAndName(v.Tags)
-	name := ""
-	if namep != nil {
-		name = *namep
+	return EC2VpcData{
+		VpcId: *v.VpcId,
+		Name:  strval(namep),
+		Cidr:  strval(v.CidrBlock),
+		Tags:  tags,
 	}
-	keyval := []string{}
-	for k, v := range tags {
-		keyval = append(keyval, fmt.Sprintf("%s:%s", k, v))
+}
+
+func (d EC2VpcData) String() string {
+	return fmt.Sprintf("%s:%s:%v", d.VpcId, d.Name, EC2TagList(d.Tags))
+}
+
+type EC2SubnetData struct {
+	SubnetId         string            `json:"subnetid"`
+	Name             string            `json:"name"`
+	AvailabilityZone string            `json:"az"`
+	VpcId            string            `json:"vpcid"`
+	Cidr             string            `json:"cidr"`
+	Tags             map[string]string `json:"tags"`
+}
+
+func NewEC2SubnetData(v types.Subnet) EC2SubnetData {
+	tags, namep := EC2GetTagsAndName(v.Tags)
+	return EC2SubnetData{
+		SubnetId:         *v.SubnetId,
+		Name:             strval(namep),
+		AvailabilityZone: strval(v.AvailabilityZone),
+		VpcId:            strval(v.VpcId),
+		Cidr:             strval(v.CidrBlock),
+		Tags:             tags,
 	}
-	sort.Slice(keyval, func(a, b int) bool {
-		return keyval[a] < keyval[b]
-	})
-	return fmt.Sprintf("%s:%s:%s:%s:%v", *v.SubnetId, name, *v.AvailabilityZone, *v.VpcId, keyval)
 }
 
-func EC2SecurityGroupString(sg types.SecurityGroup) string {
+func (d EC2SubnetData) String() string {
+	return fmt.Sprintf("%s:%s:%s:%s:%v", d.SubnetId, d.Name, d.AvailabilityZone, d.VpcId, EC2TagList(d.Tags))
+}
+
+type EC2PermissionData struct {
+	Protocol string   `json:"protocol"`
+	FromPort string   `json:"fromport"`
+	ToPort   string   `json:"toport"`
+	Ranges   []string `json:"ranges"`
+}
+
+func (d EC2PermissionData) String() string {
+	return fmt.Sprintf("{%s:%s:%s:%v}", d.Protocol, d.FromPort, d.ToPort, d.Ranges)
+}
+
+type EC2SecurityGroupData struct {
+	GroupId     string              `json:"groupid"`
+	GroupName   string              `json:"groupname"`
+	VpcId       string              `json:"vpcid"`
+	Tags        map[string]string   `json:"tags"`
+	Permissions []EC2PermissionData `json:"permissions"`
+}
+
+func NewEC2SecurityGroupData(sg types.SecurityGroup) EC2SecurityGroupData {
 	tags, _ := EC2GetTagsAndName(sg.Tags)
-	keyval := []string{}
-	for k, v := range tags {
-		keyval = append(keyval, fmt.Sprintf("%s:%s", k, v))
-	}
-	sort.Slice(keyval, func(a, b int) bool {
-		return keyval[a] < keyval[b]
-	})
-	groupname := ""
-	if sg.GroupName != nil {
-		groupname = *sg.GroupName
-	}
-	perms := []string{}
+	perms := []EC2PermissionData{}
 	for _, perm := range sg.IpPermissions {
-		fr := "from"
-		to := "to"
-		pr := "proto"
+		p := EC2PermissionData{
+			Protocol: "proto",
+			FromPort: "from",
+			ToPort:   "to",
+			Ranges:   []string{},
+		}
 		if perm.FromPort != nil {
-			fr = fmt.Sprintf("%d", *perm.FromPort)
+			p.FromPort = fmt.Sprintf("%d", *perm.FromPort)
 		}
 		if perm.ToPort != nil {
-			to = fmt.Sprintf("%d", *perm.ToPort)
+			p.ToPort = fmt.Sprintf("%d", *perm.ToPort)
 		}
 		if perm.IpProtocol != nil {
-			pr = *perm.IpProtocol
-			if pr == "-1" {
-				pr = "ALL"
+			p.Protocol = *perm.IpProtocol
+			if p.Protocol == "-1" {
+				p.Protocol = "ALL"
 			}
 		}
-		ipr := []string{}
 		for _, i := range perm.IpRanges {
 			if i.CidrIp != nil {
-				ipr = append(ipr, *i.CidrIp)
+				p.Ranges = append(p.Ranges, *i.CidrIp)
 			}
 		}
 		for _, i := range perm.PrefixListIds {
 			if i.PrefixListId != nil {
-				ipr = append(ipr, *i.PrefixListId)
+				p.Ranges = append(p.Ranges, *i.PrefixListId)
 			}
 		}
-		perms = append(perms, fmt.Sprintf("{%s:%s:%s:%v}",
-			pr, fr, to, ipr))
+		perms = append(perms, p)
+	}
+	return EC2SecurityGroupData{
+		GroupId:     *sg.GroupId,
+		GroupName:   strval(sg.GroupName),
+		VpcId:       strval(sg.VpcId),
+		Tags:        tags,
+		Permissions: perms,
+	}
+}
+
+func (d EC2SecurityGroupData) String() string {
+	perms := []string{}
+	for _, p := range d.Permissions {
+		perms = append(perms, p.String())
 	}
 	return fmt.Sprintf("%s:%s:%s:%v:%v",
-		*sg.GroupId, groupname, *sg.VpcId, keyval, perms)
+		d.GroupId, d.GroupName, d.VpcId, EC2TagList(d.Tags), perms)
+}
+
+type EC2NetworkInterfaceData struct {
+	NetworkInterfaceId string            `json:"nic"`
+	VpcId              string            `json:"vpcid"`
+	SubnetId           string            `json:"subnetid"`
+	InstanceId         string            `json:"instanceid"`
+	PrivateIp          string            `json:"privateip"`
+	PublicIp           string            `json:"publicip"`
+	Tags               map[string]string `json:"tags"`
 }
 
-func EC2NetworkInterfaceString(nic types.NetworkInterface) string {
+func NewEC2NetworkInterfaceData(nic types.NetworkInterface) EC2NetworkInterfaceData {
 	tags, _ := EC2GetTagsAndName(nic.TagSet)
-	keyval := []string{}
-	for k, v := range tags {
-		keyval = append(keyval, fmt.Sprintf("%s:%s", k, v))
+	d := EC2NetworkInterfaceData{
+		NetworkInterfaceId: *nic.NetworkInterfaceId,
+		VpcId:              strval(nic.VpcId),
+		SubnetId:           strval(nic.SubnetId),
+		PrivateIp:          strval(nic.PrivateIpAddress),
+		Tags:               tags,
 	}
-	sort.Slice(keyval, func(a, b int) bool {
-		return keyval[a] < keyval[b]
-	})
-	attach := ""
 	if nic.Attachment != nil {
-		if nic.Attachment.InstanceId != nil {
-			attach = *nic.Attachment.InstanceId
-		}
+		d.InstanceId = strval(nic.Attachment.InstanceId)
 	}
-	pubip := ""
 	if nic.Association != nil {
 		assoc := nic.Association
 		if assoc.PublicIp != nil {
-			pubip = *assoc.PublicIp
+			d.PublicIp = *assoc.PublicIp
 		} else if assoc.CarrierIp != nil {
-			pubip = *assoc.CarrierIp
+			d.PublicIp = *assoc.CarrierIp
 		}
 	}
+	return d
+}
+
+func (d EC2NetworkInterfaceData) String() string {
 	return fmt.Sprintf("%s:%s:%s:%s:%s:%s:%v",
-		*nic.NetworkInterfaceId,
-		*nic.VpcId,
-		*nic.SubnetId,
-		attach,
-		*nic.PrivateIpAddress, pubip, keyval)
+		d.NetworkInterfaceId, d.VpcId, d.SubnetId, d.InstanceId,
+		d.PrivateIp, d.PublicIp, EC2TagList(d.Tags))
+}
+
+type EC2VolumeData struct {
+	VolumeId         string            `json:"volumeid"`
+	Name             string            `json:"name"`
+	VolumeType       string            `json:"volumetype"`
+	Size             int32             `json:"size"`
+	State            string            `json:"state"`
+	InstanceId       string            `json:"instanceid"`
+	AvailabilityZone string            `json:"az"`
+	Tags             map[string]string `json:"tags"`
 }
 
-func EC2VolumeString(vol types.Volume) string {
+func NewEC2VolumeData(vol types.Volume) EC2VolumeData {
 	tags, namep := EC2GetTagsAndName(vol.Tags)
-	keyval := []string{}
-	for k, v := range tags {
-		keyval = append(keyval, fmt.Sprintf("%s:%s", k, v))
+	d := EC2VolumeData{
+		VolumeId:         *vol.VolumeId,
+		Name:             strval(namep),
+		VolumeType:       string(vol.VolumeType),
+		State:            string(vol.State),
+		AvailabilityZone: strval(vol.AvailabilityZone),
+		Tags:             tags,
 	}
-	sort.Slice(keyval, func(a, b int) bool {
-		return keyval[a] < keyval[b]
-	})
-	name := ""
-	if namep != nil {
-		name = *namep
-	}
-	state := vol.State
-	attach := ""
 	for _, att := range vol.Attachments {
-		attach = *att.InstanceId
+		d.InstanceId = strval(att.InstanceId)
 	}
-	var size int32 = 0
 	if vol.Size != nil {
-		size = *vol.Size
+		d.Size = *vol.Size
 	}
+	return d
+}
+
+func (d EC2VolumeData) String() string {
 	return fmt.Sprintf("%s:%s:%s:%d:%s:%s:%s:%v",
-		*vol.VolumeId, name, vol.VolumeType, size,
-		state, attach, *vol.AvailabilityZone, keyval)
+		d.VolumeId, d.Name, d.VolumeType, d.Size,
+		d.State, d.InstanceId, d.AvailabilityZone, EC2TagList(d.Tags))
+}
+
+type EC2ImageData struct {
+	ImageId      string `json:"imageid"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	CreationDate string `json:"creationdate"`
+}
+
+func NewEC2ImageData(i types.Image) EC2ImageData {
+	return EC2ImageData{
+		ImageId:      *i.ImageId,
+		Name:         strval(i.Name),
+		Description:  strval(i.Description),
+		CreationDate: strval(i.CreationDate),
+	}
 }
 
-func EC2ImageString(i types.Image) string {
-	desc := ""
-	if i.Description != nil {
-		desc = *i.Description
+func (d EC2ImageData) String() string {
+	return fmt.Sprintf("%s:%s:%s", d.ImageId, d.Name, d.Description)
+}
+
+type EC2StateChangeData struct {
+	InstanceId    string `json:"instanceid"`
+	PreviousState string `json:"previousstate"`
+	CurrentState  string `json:"currentstate"`
+}
+
+func NewEC2StateChangeData(i types.InstanceStateChange) EC2StateChangeData {
+	d := EC2StateChangeData{
+		InstanceId: *i.InstanceId,
+	}
+	if i.PreviousState != nil {
+		d.PreviousState = string(i.PreviousState.Name)
+	}
+	if i.CurrentState != nil {
+		d.CurrentState = string(i.CurrentState.Name)
 	}
-	return fmt.Sprintf("%s:%s:%s",
-		*i.ImageId, *i.Name, desc)
+	return d
 }
 
-func EC2StateChangeString(i types.InstanceStateChange) string {
+func (d EC2StateChangeData) String() string {
 	return fmt.Sprintf("%s:%s to %s",
-		*i.InstanceId, i.PreviousState.Name, i.CurrentState.Name)
+		d.InstanceId, d.PreviousState, d.CurrentState)
 }
 
 func EC2BlockDeviceMappings(volsz int32, voltype string) []types.BlockDeviceMapping {
@@ -4,31 +4,28 @@ package main
 import (
 	"context"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/ecs"
 	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
 )
 
 type ECSClient struct {
+	ctx    context.Context
 	client *ecs.Client
 }
 
-func NewECSClient() (*ECSClient, error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		return nil, err
-	}
-	client := &ECSClient{
+func NewECSClient(ctx context.Context, cfg aws.Config) *ECSClient {
+	return &ECSClient{
+		ctx:    ctx,
 		client: ecs.NewFromConfig(cfg),
 	}
-	return client, nil
 }
 
 func (cli *ECSClient) DescribeClusters(arns []string) ([]types.Cluster, error) {
 	input := &ecs.DescribeClustersInput{
 		Clusters: arns,
 	}
-	output, err := cli.client.DescribeClusters(context.TODO(), input)
+	output, err := cli.client.DescribeClusters(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -37,7 +34,7 @@ func (cli *ECSClient) DescribeClusters(arns []string) ([]types.Cluster, error) {
 
 func (cli *ECSClient) ListClusters() ([]string, error) {
 	input := &ecs.ListClustersInput{}
-	output, err := cli.client.ListClusters(context.TODO(), input)
+	output, err := cli.client.ListClusters(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -48,7 +45,7 @@ func (cli *ECSClient) DescribeTaskDefinition(arn string) (*types.TaskDefinition,
 	input := &ecs.DescribeTaskDefinitionInput{
 		TaskDefinition: &arn,
 	}
-	output, err := cli.client.DescribeTaskDefinition(context.TODO(), input)
+	output, err := cli.client.DescribeTaskDefinition(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -57,7 +54,7 @@ func (cli *ECSClient) DescribeTaskDefinition(arn string) (*types.TaskDefinition,
 
 func (cli *ECSClient) ListTaskDefinitions() ([]string, error) {
 	input := &ecs.ListTaskDefinitionsInput{}
-	output, err := cli.client.ListTaskDefinitions(context.TODO(), input)
+	output, err := cli.client.ListTaskDefinitions(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +65,7 @@ func (cli *ECSClient) DeregisterTaskDefinition(family string) (*types.TaskDefini
 	input := &ecs.DeregisterTaskDefinitionInput{
 		TaskDefinition: &family,
 	}
-	output, err := cli.client.DeregisterTaskDefinition(context.TODO(), input)
+	output, err := cli.client.DeregisterTaskDefinition(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -90,7 +87,7 @@ func (cli *ECSClient) RegisterTaskDefinition(family, cpu, memory, execrole, cnam
 		NetworkMode:             "awsvpc",
 		RequiresCompatibilities: []types.Compatibility{"FARGATE"},
 	}
-	output, err := cli.client.RegisterTaskDefinition(context.TODO(), input)
+	output, err := cli.client.RegisterTaskDefinition(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -102,7 +99,7 @@ func (cli *ECSClient) DescribeTasks(arns []string, cluster string) ([]types.Task
 		Tasks:   arns,
 		Cluster: &cluster,
 	}
-	output, err := cli.client.DescribeTasks(context.TODO(), input)
+	output, err := cli.client.DescribeTasks(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -113,7 +110,7 @@ func (cli *ECSClient) ListTasks(cluster string) ([]string, error) {
 	input := &ecs.ListTasksInput{
 		Cluster: &cluster,
 	}
-	output, err := cli.client.ListTasks(context.TODO(), input)
+	output, err := cli.client.ListTasks(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -164,7 +161,7 @@ func (cli *ECSClient) RunTask(taskdefp *types.TaskDefinition, spot bool, count i
 		input.EnableExecuteCommand = true
 		input.Overrides.TaskRoleArn = taskrolep
 	}
-	output, err := cli.client.RunTask(context.TODO(), input)
+	output, err := cli.client.RunTask(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -176,7 +173,7 @@ func (cli *ECSClient) StopTask(arn, cluster string) (*types.Task, error) {
 		Task:    &arn,
 		Cluster: &cluster,
 	}
-	output, err := cli.client.StopTask(context.TODO(), input)
+	output, err := cli.client.StopTask(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
@@ -190,7 +187,7 @@ func (cli *ECSClient) ExecuteCommand(arn, cluster, cmd string) error {
 		Cluster:     &cluster,
 		Interactive: true,
 	}
-	_, err := cli.client.ExecuteCommand(context.TODO(), input)
+	_, err := cli.client.ExecuteCommand(cli.ctx, input)
 	return err
 }
 
@@ -211,6 +208,6 @@ func (cli *ECSClient) TagResource(arn string, kvs map[string]string) error {
 		ResourceArn: &arn,
 		Tags:        tags,
 	}
-	_, err := cli.client.TagResource(context.TODO(), input)
+	_, err := cli.client.TagResource(cli.ctx, input)
 	return err
 }
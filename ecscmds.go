@@ -0,0 +1,340 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name:        "ecs.clusters",
+			Description: "list and describe clusters",
+			Handler:     withECS((*Session).doECSClusters),
+			Fanout:      true,
+		},
+		&Command{
+			Name:        "ecs.taskdefs",
+			Description: "list task definitions",
+			Handler:     withECS((*Session).doECSTaskDefinitions),
+		},
+		&Command{
+			Name:        "ecs.taskdef",
+			Description: "describe a task definition",
+			Required:    []string{"family|arn"},
+			Handler:     withECS((*Session).doECSTaskDefinition),
+		},
+		&Command{
+			Name:        "ecs.regtaskdef",
+			Description: "register a Fargate task definition",
+			Required:    []string{"family", "execrole", "cpu", "memory"},
+			Optional:    []string{"name", "image"},
+			Handler:     withECS((*Session).doECSRegisterTaskDefinition),
+			Rollback:    rollbackECSRegisterTaskDefinition,
+			Mutating:    true,
+		},
+		&Command{
+			Name:        "ecs.deregtaskdef",
+			Description: "deregister a task definition",
+			Required:    []string{"family"},
+			Handler:     withECS((*Session).doECSDeregisterTaskDefinition),
+			Mutating:    true,
+		},
+		&Command{
+			Name:        "ecs.tasks",
+			Description: "describe tasks in a cluster",
+			Required:    []string{"cluster"},
+			Handler:     withECS((*Session).doECSTasks),
+			Fanout:      true,
+		},
+		&Command{
+			Name:        "ecs.tasksraw",
+			Description: "same as ecs.tasks with raw JSON",
+			Required:    []string{"cluster"},
+			Handler:     withECS((*Session).doECSTasks),
+		},
+		&Command{
+			Name:        "ecs.runtask",
+			Description: "run Fargate tasks, use ecs.runtask.spot for Fargate Spot",
+			Required:    []string{"arn", "name", "cluster", "subnetid", "securitygroupids", "execcommand"},
+			Optional:    []string{"count", "associatepublicip", "group", "taskrole", "cpu", "memory", "tags"},
+			Handler:     withECS((*Session).doECSRunTask),
+			Rollback:    rollbackECSRunTask,
+			Mutating:    true,
+		},
+		&Command{
+			Name:        "ecs.stoptask",
+			Description: "stop tasks",
+			Required:    []string{"cluster", "arn|arns"},
+			Handler:     withECS((*Session).doECSStopTask),
+			Mutating:    true,
+		},
+		&Command{
+			Name:        "ecs.exec",
+			Description: "execute a command in tasks",
+			Required:    []string{"cluster", "execcommand", "arn|arns"},
+			Handler:     withECS((*Session).doECSExec),
+			Mutating:    true,
+		},
+		&Command{
+			Name:        "ecs.tag",
+			Description: "tag resources",
+			Required:    []string{"tags", "arn|arns"},
+			Handler:     withECS((*Session).doECSTag),
+			Mutating:    true,
+		},
+	)
+}
+
+func rollbackECSRunTask(res *Result) *PostRequest {
+	arns := outputStrings(res, "arns")
+	cluster := outputString(res, "cluster")
+	if len(arns) == 0 || cluster == nil {
+		return nil
+	}
+	return &PostRequest{Command: "ecs.stoptask", Cluster: cluster, ARNs: arns}
+}
+
+func rollbackECSRegisterTaskDefinition(res *Result) *PostRequest {
+	arn := outputString(res, "arn")
+	if arn == nil {
+		return nil
+	}
+	return &PostRequest{Command: "ecs.deregtaskdef", Family: arn}
+}
+
+func withECS(f func(*Session, *ECSClient, PostRequest)) func(*Session, PostRequest) {
+	return func(s *Session, req PostRequest) {
+		cfg, err := s.awsConfig(req)
+		if err != nil {
+			s.Failf(http.StatusInternalServerError, "LoadAWSConfig: %v", err)
+			return
+		}
+		cli := NewECSClient(s.ctx, cfg)
+		f(s, cli, req)
+	}
+}
+
+func (s *Session) doECSClusters(cli *ECSClient, req PostRequest) {
+	// DescribeClusters API requires cluster names or ARNs
+	// first get ARNs with ListClusters
+	s.Logf("list clusters")
+	arns, err := cli.ListClusters()
+	if err != nil {
+		s.Errorf("ListClusters: %v", err)
+		return
+	}
+	for _, arn := range arns {
+		s.Logf("%s", arn)
+	}
+	// then, call DescribeClusters with ARNs
+	s.Logf("describe clusters")
+	cls, err := cli.DescribeClusters(arns)
+	if err != nil {
+		s.Errorf("DescribeClusters: %v", err)
+		return
+	}
+	data := []ECSClusterData{}
+	for _, c := range cls {
+		data = append(data, NewECSClusterData(c))
+	}
+	s.Items(data)
+}
+
+func (s *Session) doECSTaskDefinitions(cli *ECSClient, req PostRequest) {
+	arns, err := cli.ListTaskDefinitions()
+	if err != nil {
+		s.Errorf("ListTaskDefinitions: %v", err)
+		return
+	}
+	s.LogLines(arns)
+	s.SetData(arns)
+}
+
+func (s *Session) doECSTaskDefinition(cli *ECSClient, req PostRequest) {
+	family := req.Family
+	if family == nil {
+		// old compatibility
+		s.Logf("please use family")
+		family = req.ARN
+	}
+	taskdefp, err := cli.DescribeTaskDefinition(*family)
+	if err != nil {
+		s.Errorf("DescribeTaskDefinition: %v", err)
+		return
+	}
+	if taskdefp == nil {
+		s.Errorf("TaskDefinition nil\n")
+		return
+	}
+	s.Logf("%s:%d", *taskdefp.Family, taskdefp.Revision)
+	j, err := json.Marshal(taskdefp)
+	if err != nil {
+		s.Failf(http.StatusInternalServerError, "Marshal: %v", err)
+		return
+	}
+	s.Logf("taskdef: %s", j)
+	s.SetData(taskdefp)
+}
+
+func (s *Session) doECSRegisterTaskDefinition(cli *ECSClient, req PostRequest) {
+	cname := "ubuntu"
+	if req.Name != nil {
+		cname = *req.Name
+	}
+	cimage := "ubuntu:latest"
+	if req.Image != nil {
+		cimage = *req.Image
+	}
+	taskdef, err := cli.RegisterTaskDefinition(*req.Family, *req.Cpu, *req.Memory, *req.ExecRole, cname, cimage)
+	if err != nil {
+		s.Errorf("RegisterTaskDefinition: %v", err)
+		return
+	}
+	s.Logf("%+v", taskdef)
+	s.SetData(taskdef)
+	s.Output("arn", *taskdef.TaskDefinitionArn)
+}
+
+func (s *Session) doECSDeregisterTaskDefinition(cli *ECSClient, req PostRequest) {
+	taskdef, err := cli.DeregisterTaskDefinition(*req.Family)
+	if err != nil {
+		s.Errorf("DeregisterTaskDefinition: %v", err)
+		return
+	}
+	s.Logf("%+v", taskdef)
+	s.SetData(taskdef)
+}
+
+func (s *Session) doECSTasks(cli *ECSClient, req PostRequest) {
+	taskarns, err := cli.ListTasks(*req.Cluster)
+	if err != nil {
+		s.Errorf("ListTasks: %v", err)
+		return
+	}
+	if len(taskarns) == 0 {
+		s.Logf("no tasks")
+		return
+	}
+	tasks, err := cli.DescribeTasks(taskarns, *req.Cluster)
+	if err != nil {
+		s.Errorf("DescribeTasks: %v", err)
+		return
+	}
+	data := []ECSTaskData{}
+	arns := []string{}
+	for _, t := range tasks {
+		data = append(data, NewECSTaskData(t))
+		arns = append(arns, *t.TaskArn)
+	}
+	s.Items(data)
+	s.Output("arns", arns)
+	if req.cmd == "tasksraw" {
+		raw, err := json.Marshal(tasks)
+		if err != nil {
+			s.Failf(http.StatusInternalServerError, "Marshal: %v", err)
+			return
+		}
+		s.Logf("raw: %s", raw)
+	}
+}
+
+func (s *Session) doECSRunTask(cli *ECSClient, req PostRequest) {
+	var count int32 = 1
+	if req.Count != nil {
+		count = *req.Count
+		if count >= 10 {
+			s.Invalidf("count too large")
+			return
+		}
+	}
+	taskdefp, err := cli.DescribeTaskDefinition(*req.ARN)
+	if err != nil {
+		s.Errorf("DescribeTaskDefinition: %v", err)
+		return
+	}
+	if taskdefp == nil {
+		s.Errorf("TaskDefinition nil\n")
+		return
+	}
+	pubip := true
+	if req.AssociatePublicIp != nil {
+		pubip = *req.AssociatePublicIp
+	}
+	spot := len(req.args) > 0 && req.args[0] == "spot"
+	tasks, err := cli.RunTask(taskdefp, spot, count, req.Group, req.TaskRole, req.Cpu, req.Memory, *req.Name, *req.Cluster, *req.SubnetId, pubip, req.SecurityGroupIds, req.ExecCommand)
+	if err != nil {
+		s.Errorf("RunTask: %v", err)
+		return
+	}
+	if req.Tags != nil {
+		s.Logf("TagResource: %v", req.Tags)
+		for _, task := range tasks {
+			arn := *task.TaskArn
+			err := cli.TagResource(arn, req.Tags)
+			if err != nil {
+				s.Errorf("task:%s %v", arn, err)
+			}
+		}
+	}
+	arns := []string{}
+	for _, task := range tasks {
+		s.Logf("starting %s", *task.TaskArn)
+		arns = append(arns, *task.TaskArn)
+	}
+	s.SetData(arns)
+	if len(arns) > 0 {
+		s.Output("arn", arns[0])
+	}
+	s.Output("arns", arns)
+	s.Output("cluster", *req.Cluster)
+}
+
+func (s *Session) doECSStopTask(cli *ECSClient, req PostRequest) {
+	arns := req.ARNs
+	if len(arns) == 0 {
+		arns = []string{*req.ARN}
+	}
+	stopped := []string{}
+	for _, arn := range arns {
+		task, err := cli.StopTask(arn, *req.Cluster)
+		if err != nil {
+			s.Errorf("StopTask: %v", err)
+			continue
+		}
+		s.Logf("stopping %s", *task.TaskArn)
+		stopped = append(stopped, *task.TaskArn)
+	}
+	s.SetData(stopped)
+}
+
+func (s *Session) doECSExec(cli *ECSClient, req PostRequest) {
+	cmd := strings.Join(req.ExecCommand, " ")
+	arns := req.ARNs
+	if len(arns) == 0 {
+		arns = []string{*req.ARN}
+	}
+	for _, arn := range arns {
+		s.Logf("exec %s on %s", cmd, arn)
+		err := cli.ExecuteCommand(arn, *req.Cluster, cmd)
+		if err != nil {
+			s.Errorf("ExecuteCommand: %v", err)
+		}
+	}
+}
+
+func (s *Session) doECSTag(cli *ECSClient, req PostRequest) {
+	arns := req.ARNs
+	if len(arns) == 0 {
+		arns = []string{*req.ARN}
+	}
+	for _, arn := range arns {
+		s.Logf("tags %v on %s", req.Tags, arn)
+		err := cli.TagResource(arn, req.Tags)
+		if err != nil {
+			s.Errorf("TagResource: %v", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/ecs/types"
+)
+
+type ECSClusterData struct {
+	ClusterName  string `json:"name"`
+	ClusterArn   string `json:"arn"`
+	Status       string `json:"status"`
+	RunningTasks int32  `json:"runningtasks"`
+	PendingTasks int32  `json:"pendingtasks"`
+}
+
+func NewECSClusterData(c types.Cluster) ECSClusterData {
+	return ECSClusterData{
+		ClusterName:  strval(c.ClusterName),
+		ClusterArn:   strval(c.ClusterArn),
+		Status:       strval(c.Status),
+		RunningTasks: c.RunningTasksCount,
+		PendingTasks: c.PendingTasksCount,
+	}
+}
+
+func (d ECSClusterData) String() string {
+	return d.ClusterName
+}
+
+type ECSTaskData struct {
+	TaskArn           string            `json:"arn"`
+	TaskDefinitionArn string            `json:"taskdef"`
+	LastStatus        string            `json:"status"`
+	Group             string            `json:"group"`
+	Details           map[string]string `json:"details"`
+}
+
+func NewECSTaskData(t types.Task) ECSTaskData {
+	d := ECSTaskData{
+		TaskArn:           strval(t.TaskArn),
+		TaskDefinitionArn: strval(t.TaskDefinitionArn),
+		LastStatus:        strval(t.LastStatus),
+		Group:             strval(t.Group),
+		Details:           map[string]string{},
+	}
+	for _, a := range t.Attachments {
+		for _, kv := range a.Details {
+			d.Details[strval(kv.Name)] = strval(kv.Value)
+		}
+	}
+	return d
+}
+
+func (d ECSTaskData) String() string {
+	lines := []string{
+		d.TaskArn,
+		fmt.Sprintf(" def: %s", d.TaskDefinitionArn),
+		fmt.Sprintf(" status: %s", d.LastStatus),
+		fmt.Sprintf(" group: %s", d.Group),
+	}
+	keys := []string{}
+	for k := range d.Details {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, fmt.Sprintf("  %s: %s", k, d.Details[k]))
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,87 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"net/http"
+)
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name:        "exec.unzip",
+			Description: "unzip an object into destination directory",
+			Required:    []string{"zipfile"},
+			Optional:    []string{"destination"},
+			Handler:     (*Session).doExecUnzip,
+			Mutating:    true,
+		},
+		&Command{
+			Name:        "exec.files",
+			Description: "list files in destination directory",
+			Optional:    []string{"destination"},
+			Handler:     (*Session).doExecFiles,
+		},
+		&Command{
+			Name:        "exec.concat",
+			Description: "concatenate files in /tmp",
+			Required:    []string{"destination", "sources"},
+			Handler:     (*Session).doExecConcat,
+			Mutating:    true,
+		},
+		&Command{
+			Name:        "exec.run",
+			Description: "run execcommand",
+			Required:    []string{"execcommand"},
+			Handler:     (*Session).doExecRun,
+			Mutating:    true,
+		},
+	)
+}
+
+func execDir(req PostRequest) string {
+	if req.Destination == "" {
+		return "/tmp"
+	}
+	return req.Destination
+}
+
+func (s *Session) doExecUnzip(req PostRequest) {
+	obj, err := s.Bucket.Get(req.Zipfile)
+	if err != nil {
+		s.Errorf("S3Get: %v", err)
+		return
+	}
+	if err := Unzip(obj, execDir(req)); err != nil {
+		s.Failf(http.StatusInternalServerError, "Unzip: %v", err)
+		return
+	}
+	s.Logf("Unzip: ok")
+}
+
+func (s *Session) doExecFiles(req PostRequest) {
+	lines, err := ExecListFiles(execDir(req))
+	if err != nil {
+		s.Failf(http.StatusInternalServerError, "ListFiles: %v", err)
+		return
+	}
+	s.LogLines(lines)
+	s.SetData(lines)
+}
+
+func (s *Session) doExecConcat(req PostRequest) {
+	if err := ExecConcat(req.Destination, req.Sources); err != nil {
+		s.Failf(http.StatusInternalServerError, "ExecConcat: %v", err)
+		return
+	}
+	s.Logf("concat ok")
+}
+
+func (s *Session) doExecRun(req PostRequest) {
+	lines, err := ExecRun(req.ExecCommand)
+	if err != nil {
+		s.Failf(http.StatusInternalServerError, "Run: %v", err)
+		return
+	}
+	s.LogLines(lines)
+	s.SetData(lines)
+}
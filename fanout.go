@@ -0,0 +1,178 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"sync"
+)
+
+// FanoutTarget is an account in FANOUT_TARGETS.
+// The commands run with the role, or the function's own credentials without it,
+// in each of the regions.
+type FanoutTarget struct {
+	Account string   `json:"account"`
+	Role    *Role    `json:"role,omitempty"`
+	Regions []string `json:"regions,omitempty"`
+}
+
+// fanoutTargets returns the pairs of account and region to run the request.
+// accounts in the request selects the accounts and regions replaces their regions.
+func fanoutTargets(req PostRequest) ([]FanoutTarget, error) {
+	configured := []FanoutTarget{}
+	if env := os.Getenv("FANOUT_TARGETS"); env != "" {
+		if err := json.Unmarshal([]byte(env), &configured); err != nil {
+			return nil, fmt.Errorf("FANOUT_TARGETS: %v", err)
+		}
+	} else {
+		// the own account only
+		configured = append(configured, FanoutTarget{Account: "self", Role: req.Role})
+	}
+	selected := map[string]bool{}
+	for _, a := range req.Accounts {
+		selected[a] = true
+	}
+	targets := []FanoutTarget{}
+	for _, t := range configured {
+		if len(selected) > 0 && !selected[t.Account] {
+			continue
+		}
+		delete(selected, t.Account)
+		regions := t.Regions
+		if len(req.Regions) > 0 {
+			regions = req.Regions
+		}
+		if len(regions) == 0 {
+			regions = []string{req.Region}
+		}
+		for _, region := range regions {
+			targets = append(targets, FanoutTarget{Account: t.Account, Role: t.Role, Regions: []string{region}})
+		}
+	}
+	for a := range selected {
+		return nil, fmt.Errorf("unknown account %s", a)
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets")
+	}
+	return targets, nil
+}
+
+// handleFanout runs the command in every target concurrently and merges the results
+// annotated with account and region
+func (s *Session) handleFanout(cmd *Command, req PostRequest) {
+	targets, err := fanoutTargets(req)
+	if err != nil {
+		s.Invalidf("fanout: %v", err)
+		return
+	}
+	concurrency := req.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	if concurrency > maxConcurrency {
+		concurrency = maxConcurrency
+	}
+	s.Logf("fanout: %d targets, concurrency %d", len(targets), concurrency)
+	children := make([]*Session, len(targets))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, t := range targets {
+		child := s.fork()
+		child.result = &Result{Command: req.Command, Status: StatusOK, Code: http.StatusOK}
+		// the merged items are rendered at once
+		child.quiet = !isTextFormat(req.Format)
+		children[i] = child
+		treq := req
+		treq.Role = t.Role
+		treq.Region = t.Regions[0]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			cmd.Handler(child, treq)
+		}()
+	}
+	wg.Wait()
+	items := []interface{}{}
+	var columns []string
+	outputs := map[string]interface{}{}
+	for i, child := range children {
+		t := targets[i]
+		region := t.Regions[0]
+		if region == "" {
+			region = "default"
+		}
+		where := t.Account + "/" + region
+		for _, line := range child.Outputs {
+			s.Logf("%s: %s", where, line)
+		}
+		res := child.result
+		if res.Status != StatusOK {
+			s.Failf(res.Code, "fanout: %s failed: %s", where, res.Error)
+			continue
+		}
+		if columns == nil {
+			if c := columnsOf(res.Data); c != nil {
+				columns = append([]string{"account", "region"}, c...)
+			}
+		}
+		items = append(items, annotate(res.Data, t.Account, region)...)
+		mergeOutputs(outputs, res.Outputs)
+	}
+	// partial results are returned even if some targets failed
+	s.SetData(items)
+	if !isTextFormat(req.Format) {
+		s.renderItems(items, columns)
+	}
+	for k, v := range outputs {
+		s.Output(k, v)
+	}
+}
+
+// annotate converts the items of data to maps which have account and region
+func annotate(data interface{}, account, region string) []interface{} {
+	if data == nil {
+		return nil
+	}
+	v := reflect.ValueOf(data)
+	elems := []interface{}{data}
+	if v.Kind() == reflect.Slice {
+		elems = nil
+		for i := 0; i < v.Len(); i++ {
+			elems = append(elems, v.Index(i).Interface())
+		}
+	}
+	items := []interface{}{}
+	for _, e := range elems {
+		item := map[string]interface{}{}
+		if b, err := json.Marshal(e); err == nil {
+			if json.Unmarshal(b, &item) != nil {
+				item = map[string]interface{}{"value": e}
+			}
+		}
+		item["account"] = account
+		item["region"] = region
+		items = append(items, item)
+	}
+	return items
+}
+
+// mergeOutputs concatenates list outputs and keeps the first of the others
+func mergeOutputs(dst, src map[string]interface{}) {
+	for k, v := range src {
+		list, ok := v.([]string)
+		if !ok {
+			if _, exists := dst[k]; !exists {
+				dst[k] = v
+			}
+			continue
+		}
+		prev, _ := dst[k].([]string)
+		dst[k] = append(prev, list...)
+	}
+}
@@ -0,0 +1,209 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	idempotencyPrefix = "idempotency/"
+	// a running record older than this is left by a function which was stopped
+	idempotencyRunningTimeout = 15 * time.Minute
+	// an older record is ignored and the command runs again
+	idempotencyTTL = 24 * time.Hour
+)
+
+// IdempotencyRecord is the result of the request with the idempotency key
+type IdempotencyRecord struct {
+	// Scope is the caller who used the key
+	Scope   string    `json:"scope"`
+	Key     string    `json:"key"`
+	Command string    `json:"command"`
+	Params  string    `json:"params"`
+	Status  string    `json:"status"`
+	Time    time.Time `json:"time"`
+	Result  *Result   `json:"result,omitempty"`
+	// ETag of the stored record
+	etag string
+}
+
+// IdempotencyToken returns the token for the key which is also used as an EC2 ClientToken
+func IdempotencyToken(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// idempotencyScope separates the keys of the callers, the signing key or the source IP without a key
+func idempotencyScope(id *Identity) string {
+	if id == nil {
+		return "anonymous"
+	}
+	if id.Key != "" {
+		return id.Method + ":" + id.Key
+	}
+	return id.Method + ":" + id.SourceIP
+}
+
+// idempotencyToken returns the token of the key used by the caller
+func (s *Session) idempotencyToken(key string) string {
+	return IdempotencyToken(idempotencyScope(s.Identity) + "/" + key)
+}
+
+func idempotencyKey(scope, key string) string {
+	return idempotencyPrefix + IdempotencyToken(scope)[:16] + "/" + IdempotencyToken(key) + ".json"
+}
+
+// expired reports whether the record is too old to be used
+func (rec *IdempotencyRecord) expired(now time.Time) bool {
+	return now.Sub(rec.Time) >= idempotencyTTL
+}
+
+// paramsHash identifies the parameters of the request
+func paramsHash(params map[string]interface{}) string {
+	b, _ := json.Marshal(params)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (b *Bucket) GetIdempotencyRecord(scope, key string) (*IdempotencyRecord, error) {
+	body, etag, err := b.GetWithETag(idempotencyKey(scope, key))
+	if err != nil {
+		return nil, err
+	}
+	rec := &IdempotencyRecord{}
+	if err := json.Unmarshal(body, rec); err != nil {
+		return nil, err
+	}
+	rec.etag = etag
+	if rec.Result != nil {
+		normalizeOutputs(rec.Result.Outputs)
+	}
+	return rec, nil
+}
+
+func (b *Bucket) PutIdempotencyRecord(rec *IdempotencyRecord) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return b.Put(idempotencyKey(rec.Scope, rec.Key), body)
+}
+
+// CreateIdempotencyRecord writes the record only when nobody has written it
+// since it was read as prev, or since it was found missing with nil
+func (b *Bucket) CreateIdempotencyRecord(rec, prev *IdempotencyRecord) error {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	etag := ""
+	if prev != nil {
+		etag = prev.etag
+	}
+	return b.PutIf(idempotencyKey(rec.Scope, rec.Key), body, etag)
+}
+
+// normalizeOutputs makes the lists of strings read from JSON []string again
+// so that ${steps.x.y} and the rollback can use them
+func normalizeOutputs(outputs map[string]interface{}) {
+	for k, v := range outputs {
+		list, ok := v.([]interface{})
+		if !ok {
+			continue
+		}
+		strs := make([]string, 0, len(list))
+		for _, e := range list {
+			str, ok := e.(string)
+			if !ok {
+				break
+			}
+			strs = append(strs, str)
+		}
+		if len(strs) == len(list) {
+			outputs[k] = strs
+		}
+	}
+}
+
+// beginIdempotent returns the record to finish after the command runs,
+// or nil when the stored result was returned or the request failed
+func (s *Session) beginIdempotent(req PostRequest, res *Result) *IdempotencyRecord {
+	if s.Bucket == nil {
+		s.Failf(http.StatusInternalServerError, "idempotencykey: no bucket")
+		return nil
+	}
+	scope := idempotencyScope(s.Identity)
+	params := paramsHash(res.params)
+	prev, err := s.Bucket.GetIdempotencyRecord(scope, req.IdempotencyKey)
+	if err == nil && prev.expired(time.Now()) {
+		// too old to replay, run it again
+		s.Logf("idempotency key %s: the record of %s is expired", req.IdempotencyKey, prev.Time.Format(time.RFC3339))
+	} else if err == nil {
+		if prev.Command != req.Command || prev.Params != params {
+			s.Failf(http.StatusConflict, "idempotency key %s was used for another %s request", req.IdempotencyKey, prev.Command)
+			return nil
+		}
+		switch {
+		case prev.Status == StatusOK && prev.Result != nil:
+			s.replay(prev)
+			return nil
+		case prev.Status == "running" && time.Since(prev.Time) < idempotencyRunningTimeout:
+			s.Failf(http.StatusConflict, "idempotency key %s: %s is still running since %s", req.IdempotencyKey, prev.Command, prev.Time.Format(time.RFC3339))
+			return nil
+		}
+		// failed or stopped, run it again
+	}
+	rec := &IdempotencyRecord{
+		Scope:   scope,
+		Key:     req.IdempotencyKey,
+		Command: req.Command,
+		Params:  params,
+		Status:  "running",
+		Time:    time.Now().UTC(),
+	}
+	// only one of concurrent retries can write it
+	if err := s.Bucket.CreateIdempotencyRecord(rec, prev); err != nil {
+		if IsPreconditionFailed(err) {
+			s.Failf(http.StatusConflict, "idempotency key %s: %s is started by another request", req.IdempotencyKey, req.Command)
+			return nil
+		}
+		s.Errorf("idempotencykey: %v", err)
+		return nil
+	}
+	return rec
+}
+
+// finishIdempotent stores the result of the command
+func (s *Session) finishIdempotent(rec *IdempotencyRecord, res *Result) {
+	rec.Status = res.Status
+	rec.Time = time.Now().UTC()
+	if res.Status == StatusOK {
+		rec.Result = res
+	}
+	// save the result even after the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), deadlineMargin)
+	defer cancel()
+	bucket := s.Bucket.WithContext(ctx)
+	if err := bucket.PutIdempotencyRecord(rec); err != nil {
+		s.Logf("idempotencykey: %v", err)
+	}
+}
+
+// replay returns the stored result as the result of the current step
+func (s *Session) replay(rec *IdempotencyRecord) {
+	s.mu.Lock()
+	res := s.result
+	res.Status = rec.Result.Status
+	res.Code = rec.Result.Code
+	res.Data = rec.Result.Data
+	res.Outputs = rec.Result.Outputs
+	res.Replay = true
+	s.mu.Unlock()
+	s.Logf("idempotency key %s: %s already ran at %s", rec.Key, rec.Command, rec.Time.Format(time.RFC3339))
+	s.LogLines(rec.Result.Logs)
+}
@@ -0,0 +1,65 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalizeOutputs(t *testing.T) {
+	res := &Result{Outputs: map[string]interface{}{
+		"instanceid":  "i-1",
+		"instanceids": []string{"i-1", "i-2"},
+		"empty":       []string{},
+		"count":       2,
+	}}
+	b, err := json.Marshal(res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed := &Result{}
+	if err := json.Unmarshal(b, replayed); err != nil {
+		t.Fatal(err)
+	}
+	replayed.Outputs["mixed"] = []interface{}{"a", 1.0}
+	normalizeOutputs(replayed.Outputs)
+	if got := outputStrings(replayed, "instanceids"); !reflect.DeepEqual(got, []string{"i-1", "i-2"}) {
+		t.Errorf("instanceids %#v", replayed.Outputs["instanceids"])
+	}
+	if _, ok := replayed.Outputs["empty"].([]string); !ok {
+		t.Errorf("empty %#v", replayed.Outputs["empty"])
+	}
+	if _, ok := replayed.Outputs["mixed"].([]interface{}); !ok {
+		t.Errorf("mixed %#v", replayed.Outputs["mixed"])
+	}
+	if *outputString(replayed, "instanceid") != "i-1" || replayed.Outputs["count"] != 2.0 {
+		t.Errorf("outputs %#v", replayed.Outputs)
+	}
+}
+
+func TestIdempotencyScope(t *testing.T) {
+	ci := &Session{Identity: &Identity{Method: "hmac", SourceIP: "192.0.2.1", Key: "ci"}}
+	moved := &Session{Identity: &Identity{Method: "hmac", SourceIP: "198.51.100.1", Key: "ci"}}
+	ops := &Session{Identity: &Identity{Method: "hmac", SourceIP: "192.0.2.1", Key: "ops"}}
+	ip := &Session{Identity: &Identity{Method: "ip", SourceIP: "192.0.2.1"}}
+	if ci.idempotencyToken("k") != moved.idempotencyToken("k") {
+		t.Errorf("the same signing key has another token")
+	}
+	keys := map[string]bool{}
+	for _, s := range []*Session{ci, ops, ip, {}} {
+		keys[idempotencyKey(idempotencyScope(s.Identity), "k")] = true
+		keys[s.idempotencyToken("k")] = true
+	}
+	if len(keys) != 8 {
+		t.Errorf("keys are shared between callers %v", keys)
+	}
+	now := time.Now()
+	if (&IdempotencyRecord{Time: now.Add(-time.Hour)}).expired(now) {
+		t.Errorf("recent record is expired")
+	}
+	if !(&IdempotencyRecord{Time: now.Add(-idempotencyTTL)}).expired(now) {
+		t.Errorf("old record is not expired")
+	}
+}
@@ -0,0 +1,224 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+
+	jobPrefix = "jobs/"
+	// how often a running job saves its progress and checks cancellation
+	jobProgressInterval = 2 * time.Second
+	// deadline of a job run in the local server
+	localJobTimeout = 15 * time.Minute
+)
+
+// Job is an asynchronous request stored in the bucket
+type Job struct {
+	Id       string      `json:"id"`
+	Status   string      `json:"status"`
+	Request  PostRequest `json:"request"`
+	SourceIP string      `json:"sourceip,omitempty"`
+	Identity *Identity   `json:"identity,omitempty"`
+	Created  time.Time   `json:"created"`
+	Started  *time.Time  `json:"started,omitempty"`
+	Finished *time.Time  `json:"finished,omitempty"`
+	Code     int         `json:"code,omitempty"`
+	Summary  string      `json:"summary,omitempty"`
+	Results  []*Result   `json:"results,omitempty"`
+	Errors   []string    `json:"errors,omitempty"`
+	Outputs  []string    `json:"outputs,omitempty"`
+}
+
+// JobEvent is the payload of the asynchronous invocation
+type JobEvent struct {
+	Toolbox string `json:"toolbox"`
+	JobId   string `json:"jobid"`
+}
+
+func (j *Job) String() string {
+	return fmt.Sprintf("%s %s %s", j.Id, j.Status, j.Summary)
+}
+
+func jobKey(id string) string {
+	return jobPrefix + id + ".json"
+}
+
+func jobCancelKey(id string) string {
+	return jobPrefix + id + ".cancel"
+}
+
+func newJobId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (b *Bucket) GetJob(id string) (*Job, error) {
+	if err := CheckFilename(id); err != nil {
+		return nil, err
+	}
+	body, err := b.Get(jobKey(id))
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{}
+	if err := json.Unmarshal(body, job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+func (b *Bucket) PutJob(job *Job) error {
+	body, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return b.Put(jobKey(job.Id), body)
+}
+
+// JobCancelled reports job.cancel was requested
+func (b *Bucket) JobCancelled(id string) bool {
+	_, err := b.Get(jobCancelKey(id))
+	return err == nil
+}
+
+// startJob stores the request as a job and invokes the function asynchronously to run it
+func (s *Session) startJob(req PostRequest) {
+	res := &Result{Command: "async", Status: StatusOK, Code: http.StatusOK}
+	s.Results = append(s.Results, res)
+	s.result = res
+	defer func() { s.result = nil }()
+	if s.Bucket == nil {
+		s.Failf(http.StatusInternalServerError, "async: no bucket")
+		return
+	}
+	req.Async = false
+	job := &Job{
+		Id:       newJobId(),
+		Status:   JobQueued,
+		Request:  req,
+		SourceIP: s.SourceIP,
+		Identity: s.Identity,
+		Created:  time.Now().UTC(),
+	}
+	if err := s.Bucket.PutJob(job); err != nil {
+		s.Errorf("PutJob: %v", err)
+		return
+	}
+	fname := os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+	if fname == "" {
+		// not in Lambda, run it in this process
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), localJobTimeout)
+			defer cancel()
+			RunJob(ctx, job.Id)
+		}()
+	} else {
+		payload, _ := json.Marshal(JobEvent{Toolbox: "job", JobId: job.Id})
+		cfg, err := LoadAWSConfig(s.ctx, "")
+		if err == nil {
+			err = LambdaInvokeAsync(s.ctx, cfg, fname, payload)
+		}
+		if err != nil {
+			s.Errorf("LambdaInvokeAsync: %v", err)
+			job.Status = JobFailed
+			s.Bucket.PutJob(job)
+			return
+		}
+	}
+	s.Logf("job %s is queued", job.Id)
+	s.SetData(map[string]string{"jobid": job.Id})
+	s.Output("jobid", job.Id)
+}
+
+// RunJob runs the stored request of the job and saves the result
+func RunJob(ctx context.Context, id string) error {
+	start := time.Now()
+	sctx, cancel := withDeadlineMargin(ctx)
+	defer cancel()
+	// cancel the session on job.cancel
+	sctx, cancelJob := context.WithCancel(sctx)
+	defer cancelJob()
+	s := NewSession(sctx)
+	if s.Bucket == nil {
+		return fmt.Errorf("job %s: no bucket", id)
+	}
+	job, err := s.Bucket.GetJob(id)
+	if err != nil {
+		return fmt.Errorf("job %s: %v", id, err)
+	}
+	if job.Status != JobQueued {
+		return fmt.Errorf("job %s: already %s", id, job.Status)
+	}
+	s.RequestId = "job-" + id
+	s.SourceIP = job.SourceIP
+	s.Identity = job.Identity
+	// the bucket with the invocation context to save the result after the deadline
+	bucket := s.Bucket.WithContext(ctx)
+	if bucket.JobCancelled(id) {
+		job.Status = JobCancelled
+		return bucket.PutJob(job)
+	}
+	now := time.Now().UTC()
+	job.Status = JobRunning
+	job.Started = &now
+	if err := bucket.PutJob(job); err != nil {
+		return err
+	}
+	var mu sync.Mutex
+	last := time.Now()
+	s.progress = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(last) < jobProgressInterval {
+			return
+		}
+		last = time.Now()
+		if bucket.JobCancelled(id) {
+			s.cancelled = true
+			cancelJob()
+		}
+		s.mu.Lock()
+		job.Results, job.Outputs = s.redacted()
+		s.mu.Unlock()
+		bucket.PutJob(job)
+	}
+	s.Logf("start job %s", id)
+	s.handleEventRequest(job.Request)
+	s.checkTimeout()
+	s.LogSummary()
+	s.Audit(ctx, start)
+	s.Logf("end job %s (%v)", id, time.Since(start))
+	finished := time.Now().UTC()
+	job.Finished = &finished
+	job.Code = s.StatusCode()
+	job.Summary = s.Summary()
+	s.mu.Lock()
+	job.Results, job.Outputs = s.redacted()
+	s.mu.Unlock()
+	job.Errors = s.Errors
+	switch {
+	case s.cancelled:
+		job.Status = JobCancelled
+	case job.Code == http.StatusOK:
+		job.Status = JobDone
+	default:
+		job.Status = JobFailed
+	}
+	return bucket.PutJob(job)
+}
@@ -0,0 +1,112 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name:        "job.status",
+			Description: "show the status of an async job",
+			Required:    []string{"jobid"},
+			Handler:     (*Session).doJobStatus,
+		},
+		&Command{
+			Name:        "job.output",
+			Description: "show the output and the results of an async job",
+			Required:    []string{"jobid"},
+			Handler:     (*Session).doJobOutput,
+		},
+		&Command{
+			Name:        "job.cancel",
+			Description: "cancel an async job, the running step stops and the rest are skipped",
+			Required:    []string{"jobid"},
+			Handler:     (*Session).doJobCancel,
+			Mutating:    true,
+		},
+	)
+}
+
+func (s *Session) getJob(req PostRequest) *Job {
+	if s.Bucket == nil {
+		s.Failf(http.StatusInternalServerError, "no bucket")
+		return nil
+	}
+	job, err := s.Bucket.GetJob(req.JobId)
+	if err != nil {
+		s.Failf(http.StatusNotFound, "job %s: %v", req.JobId, err)
+		return nil
+	}
+	// only the caller who started the job sees and cancels it
+	if !job.Identity.Same(s.Identity) {
+		s.Failf(http.StatusForbidden, "job %s is not allowed for %v", req.JobId, s.Identity)
+		return nil
+	}
+	return job
+}
+
+type JobStatusData struct {
+	Id       string `json:"id"`
+	Status   string `json:"status"`
+	Created  string `json:"created"`
+	Started  string `json:"started,omitempty"`
+	Finished string `json:"finished,omitempty"`
+	Steps    int    `json:"steps"`
+	Code     int    `json:"code,omitempty"`
+	Summary  string `json:"summary,omitempty"`
+}
+
+func (s *Session) doJobStatus(req PostRequest) {
+	job := s.getJob(req)
+	if job == nil {
+		return
+	}
+	data := JobStatusData{
+		Id:      job.Id,
+		Status:  job.Status,
+		Created: job.Created.Format(time.RFC3339),
+		Steps:   len(job.Results),
+		Code:    job.Code,
+		Summary: job.Summary,
+	}
+	if job.Started != nil {
+		data.Started = job.Started.Format(time.RFC3339)
+	}
+	if job.Finished != nil {
+		data.Finished = job.Finished.Format(time.RFC3339)
+	}
+	s.Logf("%s %s steps=%d %s", job.Id, job.Status, len(job.Results), job.Summary)
+	s.SetData(data)
+	s.Output("status", job.Status)
+}
+
+func (s *Session) doJobOutput(req PostRequest) {
+	job := s.getJob(req)
+	if job == nil {
+		return
+	}
+	s.LogLines(job.Outputs)
+	s.SetData(job)
+	s.Output("status", job.Status)
+}
+
+func (s *Session) doJobCancel(req PostRequest) {
+	job := s.getJob(req)
+	if job == nil {
+		return
+	}
+	switch job.Status {
+	case JobQueued, JobRunning:
+	default:
+		s.Invalidf("job %s is %s", job.Id, job.Status)
+		return
+	}
+	if err := s.Bucket.Put(jobCancelKey(job.Id), []byte(job.Id)); err != nil {
+		s.Errorf("S3Put: %v", err)
+		return
+	}
+	s.Logf("job %s cancel requested", job.Id)
+}
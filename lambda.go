@@ -4,24 +4,33 @@ package main
 import (
 	"context"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/lambda"
+	"github.com/aws/aws-sdk-go-v2/service/lambda/types"
 )
 
-func LambdaUpdateFunctionCode(fname, bucket, zipname string) error {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		return err
-	}
+func LambdaUpdateFunctionCode(ctx context.Context, cfg aws.Config, fname, bucket, zipname string) error {
 	client := lambda.NewFromConfig(cfg)
 	input := &lambda.UpdateFunctionCodeInput{
 		FunctionName: &fname,
 		S3Bucket:     &bucket,
 		S3Key:        &zipname,
 	}
-	_, err = client.UpdateFunctionCode(context.TODO(), input)
+	_, err := client.UpdateFunctionCode(ctx, input)
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// LambdaInvokeAsync queues the payload to the function
+func LambdaInvokeAsync(ctx context.Context, cfg aws.Config, fname string, payload []byte) error {
+	client := lambda.NewFromConfig(cfg)
+	input := &lambda.InvokeInput{
+		FunctionName:   &fname,
+		InvocationType: types.InvocationTypeEvent,
+		Payload:        payload,
+	}
+	_, err := client.Invoke(ctx, input)
+	return err
+}
@@ -0,0 +1,37 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"net/http"
+	"os"
+)
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name:        "lambda.update",
+			Description: "update function code with zipfile in the bucket",
+			Required:    []string{"function", "zipfile"},
+			Handler:     (*Session).doLambdaUpdate,
+			Mutating:    true,
+		},
+	)
+}
+
+func (s *Session) doLambdaUpdate(req PostRequest) {
+	bucketname := os.Getenv("BUCKET_NAME")
+	if bucketname == "" {
+		s.Failf(http.StatusInternalServerError, "no bucket")
+		return
+	}
+	cfg, err := s.awsConfig(req)
+	if err != nil {
+		s.Failf(http.StatusInternalServerError, "LoadAWSConfig: %v", err)
+		return
+	}
+	if err := LambdaUpdateFunctionCode(s.ctx, cfg, req.Function, bucketname, req.Zipfile); err != nil {
+		s.Errorf("LambdaUpdateFunctionCode: %v", err)
+		return
+	}
+	s.Logf("update ok")
+}
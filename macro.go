@@ -0,0 +1,205 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const macroPrefix = "macros/"
+
+// reference to a parameter of the macro like ${params.name}
+var paramRef = regexp.MustCompile(`\$\{params\.([^}]*)\}`)
+
+// MacroParams are the values of ${params.x} and the fields of the template
+type MacroParams map[string]interface{}
+
+// Macro is a request template stored in the bucket with the default params
+type Macro struct {
+	Name     string          `json:"name"`
+	Template json.RawMessage `json:"template"`
+	Params   MacroParams     `json:"params,omitempty"`
+}
+
+func macroKey(name string) string {
+	return macroPrefix + name + ".json"
+}
+
+func (b *Bucket) GetMacro(name string) (*Macro, error) {
+	if err := CheckFilename(name); err != nil {
+		return nil, err
+	}
+	body, err := b.Get(macroKey(name))
+	if err != nil {
+		return nil, err
+	}
+	m := &Macro{}
+	if err := json.Unmarshal(body, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (b *Bucket) PutMacro(m *Macro) error {
+	if err := CheckFilename(m.Name); err != nil {
+		return err
+	}
+	body, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return b.Put(macroKey(m.Name), body)
+}
+
+func (b *Bucket) ListMacros() ([]string, error) {
+	keys, err := b.List(macroPrefix)
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, key := range keys {
+		name := strings.TrimPrefix(key, macroPrefix)
+		if strings.HasSuffix(name, ".json") {
+			names = append(names, strings.TrimSuffix(name, ".json"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// requestFieldNames returns the json names of the request fields
+func requestFieldNames() map[string]bool {
+	names := map[string]bool{}
+	t := reflect.TypeOf(PostRequest{})
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// Refs returns the parameters referred in the template
+func (m *Macro) Refs() []string {
+	refs := []string{}
+	seen := map[string]bool{}
+	for _, r := range paramRef.FindAllStringSubmatch(string(m.Template), -1) {
+		if !seen[r[1]] {
+			seen[r[1]] = true
+			refs = append(refs, r[1])
+		}
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// Check verifies the template is a request which does not run macros
+func (m *Macro) Check() error {
+	var tmpl map[string]interface{}
+	if err := json.Unmarshal(m.Template, &tmpl); err != nil {
+		return fmt.Errorf("template: %v", err)
+	}
+	return checkMacroTemplate(tmpl)
+}
+
+func checkMacroTemplate(tmpl map[string]interface{}) error {
+	command, _ := tmpl["command"].(string)
+	if strings.HasPrefix(command, "macro.") {
+		return fmt.Errorf("template: %s in a macro", command)
+	}
+	reqs, _ := tmpl["requests"].([]interface{})
+	if command == "" && len(reqs) == 0 {
+		return fmt.Errorf("template: no command")
+	}
+	for _, r := range reqs {
+		sub, ok := r.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("template: bad request in requests")
+		}
+		if err := checkMacroTemplate(sub); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Build returns the request of the template with the parameters.
+// Parameters replace ${params.x} and the fields of the same names.
+func (m *Macro) Build(params MacroParams) (PostRequest, error) {
+	var req PostRequest
+	values := map[string]interface{}{}
+	for k, v := range m.Params {
+		values[k] = v
+	}
+	for k, v := range params {
+		values[k] = v
+	}
+	var tmpl map[string]interface{}
+	if err := json.Unmarshal(m.Template, &tmpl); err != nil {
+		return req, fmt.Errorf("template: %v", err)
+	}
+	refs := map[string]bool{}
+	for _, ref := range m.Refs() {
+		if _, ok := values[ref]; !ok {
+			return req, fmt.Errorf("missing param %s", ref)
+		}
+		refs[ref] = true
+	}
+	fields := requestFieldNames()
+	for k, v := range params {
+		switch {
+		case k == "command" || k == "requests":
+			return req, fmt.Errorf("param %s is not allowed", k)
+		case fields[k]:
+			tmpl[k] = v
+		case !refs[k]:
+			return req, fmt.Errorf("unknown param %s", k)
+		}
+	}
+	expanded := expandParams(tmpl, values).(map[string]interface{})
+	// a parameter can make the command a macro
+	if err := checkMacroTemplate(expanded); err != nil {
+		return req, err
+	}
+	raw, err := json.Marshal(expanded)
+	if err != nil {
+		return req, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return req, fmt.Errorf("request: %v", err)
+	}
+	return req, nil
+}
+
+// expandParams replaces ${params.x} in the strings.
+// A string which is a single reference becomes the value as is.
+func expandParams(v interface{}, values map[string]interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		if m := paramRef.FindStringSubmatch(v); m != nil && m[0] == v {
+			return values[m[1]]
+		}
+		return paramRef.ReplaceAllStringFunc(v, func(ref string) string {
+			return fmt.Sprintf("%v", values[paramRef.FindStringSubmatch(ref)[1]])
+		})
+	case []interface{}:
+		for i, e := range v {
+			v[i] = expandParams(e, values)
+		}
+		return v
+	case map[string]interface{}:
+		for key, e := range v {
+			v[key] = expandParams(e, values)
+		}
+		return v
+	}
+	return v
+}
@@ -0,0 +1,81 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMacroBuild(t *testing.T) {
+	m := &Macro{
+		Name:     "worker",
+		Template: []byte(`{"command":"ec2.run","imageid":"${params.ami}","name":"worker-${params.n}","tags":{"team":"${params.team}"},"instancetype":"t3.micro"}`),
+		Params:   MacroParams{"team": "a"},
+	}
+	req, err := m.Build(MacroParams{"ami": "ami-1", "n": 2, "instancetype": "t3.large"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Command != "ec2.run" || *req.ImageId != "ami-1" || *req.Name != "worker-2" {
+		t.Errorf("request %+v", req)
+	}
+	// the default param and a field of the same name
+	if !reflect.DeepEqual(req.Tags, map[string]string{"team": "a"}) || req.InstanceType != "t3.large" {
+		t.Errorf("tags %v instancetype %s", req.Tags, req.InstanceType)
+	}
+	req, err = m.Build(MacroParams{"ami": "ami-1", "n": 1, "team": "b"})
+	if err != nil || req.Tags["team"] != "b" {
+		t.Errorf("team %v %v", req.Tags, err)
+	}
+	// a single reference becomes the value as is
+	list := &Macro{Template: []byte(`{"command":"ec2.start","instanceids":"${params.ids}"}`)}
+	req, err = list.Build(MacroParams{"ids": []interface{}{"i-1", "i-2"}})
+	if err != nil || !reflect.DeepEqual(req.InstanceIds, []string{"i-1", "i-2"}) {
+		t.Errorf("instanceids %v %v", req.InstanceIds, err)
+	}
+	batch := &Macro{Template: []byte(`{"requests":[{"command":"${params.c}"}]}`)}
+	if req, err = batch.Build(MacroParams{"c": "help"}); err != nil || req.Requests[0].Command != "help" {
+		t.Errorf("batch %+v %v", req, err)
+	}
+
+	failures := []struct {
+		name   string
+		macro  *Macro
+		params MacroParams
+	}{
+		{"missing", m, MacroParams{"ami": "ami-1"}},
+		{"unknown", m, MacroParams{"ami": "ami-1", "n": 1, "nothing": "x"}},
+		{"command", m, MacroParams{"ami": "ami-1", "n": 1, "command": "ec2.terminate"}},
+		{"requests", m, MacroParams{"ami": "ami-1", "n": 1, "requests": []interface{}{}}},
+		{"bad field", m, MacroParams{"ami": "ami-1", "n": 1, "count": "many"}},
+		{"self", &Macro{Template: []byte(`{"command":"${params.c}","name":"self"}`)}, MacroParams{"c": "macro.run"}},
+		{"nested", batch, MacroParams{"c": "macro.run"}},
+		{"bad template", &Macro{Template: []byte(`[]`)}, nil},
+	}
+	for _, tt := range failures {
+		if req, err := tt.macro.Build(tt.params); err == nil {
+			t.Errorf("%s: built %+v", tt.name, req)
+		}
+	}
+}
+
+func TestMacroCheck(t *testing.T) {
+	tests := []struct {
+		template string
+		ok       bool
+	}{
+		{`{"command":"help"}`, true},
+		{`{"requests":[{"command":"help"},{"requests":[{"command":"ec2.describe"}]}]}`, true},
+		{`{"command":"macro.run","name":"x"}`, false},
+		{`{"requests":[{"command":"macro.save"}]}`, false},
+		{`{"requests":["help"]}`, false},
+		{`{"region":"us-east-1"}`, false},
+		{`"help"`, false},
+	}
+	for _, tt := range tests {
+		m := &Macro{Template: []byte(tt.template)}
+		if err := m.Check(); (err == nil) != tt.ok {
+			t.Errorf("%s: %v", tt.template, err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name:        "macro.save",
+			Description: "store a request template, ${params.x} in the template is replaced by a param",
+			Required:    []string{"name", "template"},
+			Optional:    []string{"params"},
+			Handler:     (*Session).doMacroSave,
+			Mutating:    true,
+		},
+		&Command{
+			Name:        "macro.list",
+			Description: "list macros",
+			Handler:     (*Session).doMacroList,
+		},
+		&Command{
+			Name:        "macro.show",
+			Description: "show a macro",
+			Required:    []string{"name"},
+			Handler:     (*Session).doMacroShow,
+		},
+		&Command{
+			Name:        "macro.run",
+			Description: "run a macro, params override the default params and the fields of the template",
+			Required:    []string{"name"},
+			Optional:    []string{"params"},
+			Handler:     (*Session).doMacroRun,
+		},
+	)
+}
+
+func (s *Session) doMacroSave(req PostRequest) {
+	if s.Bucket == nil {
+		s.Failf(http.StatusInternalServerError, "no bucket")
+		return
+	}
+	m := &Macro{Name: *req.Name, Template: req.Template, Params: req.Params}
+	if err := CheckFilename(m.Name); err != nil {
+		s.Invalidf("macro: %v", err)
+		return
+	}
+	if err := m.Check(); err != nil {
+		s.Invalidf("macro %s: %v", m.Name, err)
+		return
+	}
+	if err := s.Bucket.PutMacro(m); err != nil {
+		s.Errorf("PutMacro: %v", err)
+		return
+	}
+	s.Logf("macro %s saved, params: %s", m.Name, strings.Join(m.Refs(), ", "))
+}
+
+func (s *Session) doMacroList(req PostRequest) {
+	if s.Bucket == nil {
+		s.Failf(http.StatusInternalServerError, "no bucket")
+		return
+	}
+	names, err := s.Bucket.ListMacros()
+	if err != nil {
+		s.Errorf("ListMacros: %v", err)
+		return
+	}
+	s.LogLines(names)
+	s.SetData(names)
+}
+
+func (s *Session) getMacro(name string) *Macro {
+	if s.Bucket == nil {
+		s.Failf(http.StatusInternalServerError, "no bucket")
+		return nil
+	}
+	m, err := s.Bucket.GetMacro(name)
+	if err != nil {
+		s.Failf(http.StatusNotFound, "macro %s: %v", name, err)
+		return nil
+	}
+	return m
+}
+
+func (s *Session) doMacroShow(req PostRequest) {
+	m := s.getMacro(*req.Name)
+	if m == nil {
+		return
+	}
+	s.Logf("template: %s", string(m.Template))
+	if len(m.Params) > 0 {
+		params, _ := json.Marshal(m.Params)
+		s.Logf("params: %s", string(params))
+	}
+	s.Logf("refs: %s", strings.Join(m.Refs(), ", "))
+	s.SetData(m)
+}
+
+func (s *Session) doMacroRun(req PostRequest) {
+	m := s.getMacro(*req.Name)
+	if m == nil {
+		return
+	}
+	built, err := m.Build(req.Params)
+	if err != nil {
+		s.Invalidf("macro %s: %v", m.Name, err)
+		return
+	}
+	built = inherit(req, built)
+	raw, _ := json.Marshal(built)
+	s.Logf("macro %s: %s", m.Name, string(raw))
+	// the steps of the macro have their own results
+	res := s.result
+	first := len(s.Results)
+	ok := s.handlePostRequest(built)
+	s.result = res
+	if ok {
+		return
+	}
+	code := http.StatusInternalServerError
+	for _, r := range s.Results[first:] {
+		if r.Status == StatusError {
+			code = r.Code
+			break
+		}
+	}
+	s.Failf(code, "macro %s failed", m.Name)
+}
@@ -3,30 +3,64 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"net"
+	"log"
+	"mime"
+	"net/http"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-lambda-go/lambda"
-
-	ec2types "github.com/aws/aws-sdk-go-v2/service/ec2/types"
 )
 
 type Session struct {
 	Outputs []string
+	Results []*Result
+	Errors  []string
 	Bucket  *Bucket
 	Verbose bool
+	JSON    bool
+	// request id and source of the invocation
+	RequestId string
+	SourceIP  string
+	// authenticated caller
+	Identity *Identity
+	Policy   *Policy
+	// status code for errors outside of sub-requests
+	code int
+	// sub-requests which have id, shared with forked sessions
+	steps *stepMap
+	// current sub-request
+	result *Result
+	// invocation context which is done shortly before the deadline
+	ctx context.Context
+	// step number which timed out
+	timeoutStep int
+	// indexes of the outputs logged by sensitive commands
+	secret []int
+	// called after each step of an async job
+	progress func()
+	// the async job was cancelled
+	cancelled bool
+	// items are rendered by the parent session
+	quiet bool
+	mu    sync.Mutex
 }
 
-func NewSession() *Session {
+func NewSession(ctx context.Context) *Session {
 	bucketname := os.Getenv("BUCKET_NAME")
-	s := &Session{}
-	b, err := NewBucket(bucketname)
+	s := &Session{
+		steps: newStepMap(),
+		ctx:   ctx,
+	}
+	b, err := NewBucket(ctx, bucketname)
 	if err != nil {
 		s.Logf("NewBucket: %v", err)
 		// ignore error at this point
@@ -40,46 +74,70 @@ func NewSession() *Session {
 }
 
 type PostRequest struct {
-	Command           string            `json:command`
-	Function          string            `json function,omitempty`
-	Zipfile           string            `json zipfile,omitempty`
-	Destination       string            `json destination,omitempty`
-	Sources           []string          `json sources,omitempty`
-	ARN               *string           `json arn,omitempty`
-	ARNs              []string          `json arns,omitempty`
-	InstanceId        *string           `json instanceid,omitempty`
-	InstanceIds       []string          `json instanceids,omitempty`
-	VpcId             string            `json vpcid,omitempty`
-	SubnetId          *string           `json subnetid,omitempty`
-	AssociatePublicIp *bool             `json associatepublicip,omitempty`
-	ImageId           *string           `json imageid,omitempty`
-	InstanceType      string            `json instancetype,omitempty`
-	KeyName           *string           `json keyname,omitempty`
-	SecurityGroupIds  []string          `json securitygroupids,omitempty`
-	AvailabilityZone  *string           `json az,omitempty`
-	VolumeId          *string           `json volumeid,omitempty`
-	Device            *string           `json device,omitempty`
-	UserDataFile      *string           `json userdatafile,omitempty`
-	Name              *string           `json name,omitempty`
-	Owner             *string           `json owner,omitempty`
-	Tags              map[string]string `json tags,omitempty`
-	VolumeSize        *int32            `json volumesize,omitempty`
-	ProfileArn        *string           `json profilearn,omitempty`
-	ExecCommand       []string          `json execcommand,omitempty`
-	Arch              *string           `json arch,omitempty`
-	Distro            *string           `json distro,omitempty`
-	Count             *int32            `json count,omitempty`
-	Cluster           *string           `json cluster,omitempty`
-	Group             *string           `json group,omitempty`
-	TaskRole          *string           `json taskrole,omitempty`
-	Family            *string           `json family,omitempty`
-	ExecRole          *string           `json execrole,omitempty`
-	Cpu               *string           `json cpu,omitempty`
-	Memory            *string           `json memory,omitempty`
-	Image             *string           `json image,omitempty`
-	Nics              []string          `json nics,omitempty`
-	Requests          []PostRequest     `json requests,omitempty`
-	Force             *bool             `json force,omitempty`
+	Command           string            `json:"command"`
+	Id                string            `json:"id,omitempty"`
+	If                string            `json:"if,omitempty"`
+	OnError           string            `json:"onerror,omitempty"`
+	Parallel          bool              `json:"parallel,omitempty"`
+	Concurrency       int               `json:"concurrency,omitempty"`
+	DryRun            bool              `json:"dryrun,omitempty"`
+	Region            string            `json:"region,omitempty"`
+	Profile           string            `json:"profile,omitempty"`
+	Role              *Role             `json:"role,omitempty"`
+	Fanout            bool              `json:"fanout,omitempty"`
+	Accounts          []string          `json:"accounts,omitempty"`
+	Regions           []string          `json:"regions,omitempty"`
+	Function          string            `json:"function,omitempty"`
+	Zipfile           string            `json:"zipfile,omitempty"`
+	Destination       string            `json:"destination,omitempty"`
+	Sources           []string          `json:"sources,omitempty"`
+	ARN               *string           `json:"arn,omitempty"`
+	ARNs              []string          `json:"arns,omitempty"`
+	InstanceId        *string           `json:"instanceid,omitempty"`
+	InstanceIds       []string          `json:"instanceids,omitempty"`
+	VpcId             string            `json:"vpcid,omitempty"`
+	SubnetId          *string           `json:"subnetid,omitempty"`
+	AssociatePublicIp *bool             `json:"associatepublicip,omitempty"`
+	ImageId           *string           `json:"imageid,omitempty"`
+	InstanceType      string            `json:"instancetype,omitempty"`
+	KeyName           *string           `json:"keyname,omitempty"`
+	SecurityGroupIds  []string          `json:"securitygroupids,omitempty"`
+	AvailabilityZone  *string           `json:"az,omitempty"`
+	VolumeId          *string           `json:"volumeid,omitempty"`
+	Device            *string           `json:"device,omitempty"`
+	UserDataFile      *string           `json:"userdatafile,omitempty"`
+	Name              *string           `json:"name,omitempty"`
+	Owner             *string           `json:"owner,omitempty"`
+	Tags              map[string]string `json:"tags,omitempty"`
+	VolumeSize        *int32            `json:"volumesize,omitempty"`
+	ProfileArn        *string           `json:"profilearn,omitempty"`
+	ExecCommand       []string          `json:"execcommand,omitempty"`
+	Arch              *string           `json:"arch,omitempty"`
+	Distro            *string           `json:"distro,omitempty"`
+	Count             *int32            `json:"count,omitempty"`
+	Cluster           *string           `json:"cluster,omitempty"`
+	Group             *string           `json:"group,omitempty"`
+	TaskRole          *string           `json:"taskrole,omitempty"`
+	Family            *string           `json:"family,omitempty"`
+	ExecRole          *string           `json:"execrole,omitempty"`
+	Cpu               *string           `json:"cpu,omitempty"`
+	Memory            *string           `json:"memory,omitempty"`
+	Image             *string           `json:"image,omitempty"`
+	Nics              []string          `json:"nics,omitempty"`
+	Requests          []PostRequest     `json:"requests,omitempty"`
+	Force             *bool             `json:"force,omitempty"`
+	Response          string            `json:"response,omitempty"`
+	Format            string            `json:"format,omitempty"`
+	Since             string            `json:"since,omitempty"`
+	Until             string            `json:"until,omitempty"`
+	Resource          string            `json:"resource,omitempty"`
+	Pattern           string            `json:"pattern,omitempty"`
+	Async             bool              `json:"async,omitempty"`
+	JobId             string            `json:"jobid,omitempty"`
+	IdempotencyKey    string            `json:"idempotencykey,omitempty"`
+	Template          json.RawMessage   `json:"template,omitempty"`
+	Params            MacroParams       `json:"params,omitempty"`
+	Columns           []string          `json:"columns,omitempty"`
 	// parsed
 	cmd  string
 	args []string
@@ -90,7 +148,15 @@ func (s *Session) Logf(f string, args ...interface{}) {
 	if s.Verbose {
 		fmt.Printf("%s\n", out)
 	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.result != nil && s.result.sensitive {
+		s.secret = append(s.secret, len(s.Outputs))
+	}
 	s.Outputs = append(s.Outputs, out)
+	if s.result != nil {
+		s.result.Logs = append(s.result.Logs, out)
+	}
 }
 
 func (s *Session) LogLines(lines []string) {
@@ -112,957 +178,144 @@ func (s *Session) getFile(filename string) ([]byte, error) {
 	return nil, fmt.Errorf("%s is not found: (%v) (%v)", filename, err0, err1)
 }
 
-type EC2InstanceSpec struct {
-	ImageId           string
-	SecurityGroupIds  []string
-	InstanceType      string
-	KeyName           *string
-	UserData          *string
-	SubnetId          *string
-	AssociatePublicIp *bool
-	VolumeSize        int32
-	ProfileArn        *string
-	Tags              map[string]string
-}
-
-func (s *Session) newEC2InstanceSpec(req PostRequest) (*EC2InstanceSpec, error) {
-	if req.ImageId == nil {
-		return nil, fmt.Errorf("no imageid")
-	}
-	if req.Name == nil {
-		return nil, fmt.Errorf("no name")
+// handlePostRequest returns false when the request failed
+func (s *Session) handlePostRequest(req PostRequest) bool {
+	if req.Command == "" {
+		return s.handleBatch(req)
 	}
-	var userdata *string = nil
-	if req.UserDataFile != nil {
-		obj, err := s.getFile(*req.UserDataFile)
-		if err != nil {
-			return nil, fmt.Errorf("UserDataFile: %v", err)
-		}
-		data := base64.StdEncoding.EncodeToString(obj)
-		userdata = &data
+	if s.expired() {
+		s.skip(req)
+		s.timedOut(len(s.Results))
+		return false
 	}
-	tags := map[string]string{
-		"lambda-toolbox": "yes",
-		"Name":           *req.Name,
+	res := &Result{Id: req.Id, Command: req.Command, Status: StatusOK, Code: http.StatusOK}
+	s.Results = append(s.Results, res)
+	step := len(s.Results)
+	s.result = res
+	defer func() { s.result = nil }()
+	if s.progress != nil {
+		defer s.progress()
 	}
-	envtag := os.Getenv("TAGS")
-	if envtag != "" {
-		var etags map[string]string
-		if json.Unmarshal([]byte(envtag), &etags) == nil {
-			for k, v := range etags {
-				tags[k] = v
-			}
+	if req.Id != "" {
+		if !s.steps.reserve(req.Id) {
+			s.Invalidf("duplicate step id: %s", req.Id)
+			return false
 		}
+		defer s.steps.set(req.Id, res)
 	}
-	for k, v := range req.Tags {
-		tags[k] = v
-	}
-	var volumesize int32 = 8
-	if req.VolumeSize != nil {
-		volumesize = *req.VolumeSize
-	}
-	return &EC2InstanceSpec{
-		ImageId:           *req.ImageId,
-		SecurityGroupIds:  req.SecurityGroupIds,
-		InstanceType:      req.InstanceType,
-		KeyName:           req.KeyName,
-		UserData:          userdata,
-		SubnetId:          req.SubnetId,
-		AssociatePublicIp: req.AssociatePublicIp,
-		Tags:              tags,
-		VolumeSize:        volumesize,
-		ProfileArn:        req.ProfileArn,
-	}, nil
-}
-
-func (s *Session) doEC2RunInstances(cli *EC2Client, req PostRequest) {
-	ec2spec, err := s.newEC2InstanceSpec(req)
-	if err != nil {
-		s.Logf("newEC2InstanceSpec: %v", err)
-		return
-	}
-	var count int32 = 1
-	if req.Count != nil {
-		count = *req.Count
-	}
-	instances, err := cli.RunInstances(count, ec2spec)
-	if err != nil {
-		s.Logf("RunInstances: %v", err)
-		return
-	}
-	for _, i := range instances {
-		cli.SetTags(i, ec2spec.Tags)
-		s.Logf("%s", EC2InstanceString(i))
-	}
-}
-
-func (s *Session) doEC2RequestSpotInstances(cli *EC2Client, req PostRequest) {
-	ec2spec, err := s.newEC2InstanceSpec(req)
-	if err != nil {
-		s.Logf("newEC2InstanceSpec: %v", err)
-		return
-	}
-	var count int32 = 1
-	if req.Count != nil {
-		count = *req.Count
-	}
-	sirs, err := cli.RequestSpotInstances(count, ec2spec)
-	if err != nil {
-		s.Logf("RequestSpotInstances: %v", err)
-		return
-	}
-	ids := []string{}
-	for _, sir := range sirs {
-		s.Logf("id=%s", *sir.SpotInstanceRequestId)
-		ids = append(ids, *sir.SpotInstanceRequestId)
-	}
-	time.Sleep(time.Second)
-	first := true
-	for {
-		sirs, err = cli.DescribeSpotInstanceRequests(ids)
+	if req.If != "" {
+		ok, err := s.evalCondition(req.If)
 		if err != nil {
-			s.Logf("DescribeSpotInstanceRequests: %v", err)
-			if !first {
-				return
-			}
-			first = false
-			time.Sleep(time.Second)
-			continue
-		}
-		fullfilled := true
-		for _, sir := range sirs {
-			if sir.State == ec2types.SpotInstanceStateOpen {
-				s.Logf("%s is not fullfilled", *sir.SpotInstanceRequestId)
-				fullfilled = false
-			}
-			// active/closed/cancelled/failed
-		}
-		if fullfilled {
-			break
+			s.Invalidf("%s: if: %v", req.Command, err)
+			return false
 		}
-		time.Sleep(time.Second)
-	}
-	// setup tag
-	cli.InstanceIds = nil
-	cli.VpcId = nil
-	for _, sir := range sirs {
-		if sir.InstanceId != nil {
-			cli.InstanceIds = append(cli.InstanceIds, *sir.InstanceId)
+		if !ok {
+			res.Status = StatusSkipped
+			s.Logf("%s: skipped by condition %s", req.Command, req.If)
+			return true
 		}
 	}
-	if len(cli.InstanceIds) == 0 {
-		s.Logf("no activated instances")
-		return
-	}
-	instances, err := cli.DescribeInstances()
+	resolved, err := s.resolveRequest(req)
 	if err != nil {
-		s.Logf("DescribeInstances: %v", err)
-		// why?
-		return
+		s.Invalidf("%s: %v", req.Command, err)
+		return false
 	}
-	// mark spot instance
-	ec2spec.Tags["SpotInstance"] = "yes"
-	for _, i := range instances {
-		cli.SetTags(i, ec2spec.Tags)
+	req = resolved
+	res.req = req
+	res.params = requestParams(req)
+	cmd, args := LookupCommand(req.Command)
+	if cmd == nil {
+		s.Failf(http.StatusNotFound, "unknown command: %s", req.Command)
+		return false
 	}
-}
-
-func parseInstanceIds(req PostRequest) ([]string, error) {
-	ids := req.InstanceIds
-	if req.InstanceId != nil {
-		ids = append(ids, *req.InstanceId)
-	}
-	if len(ids) == 0 {
-		return nil, fmt.Errorf("no instance ids")
-	}
-	return ids, nil
-}
-
-func (s *Session) showInstancesState(instances []ec2types.InstanceStateChange) {
-	for _, i := range instances {
-		s.Logf("%s", EC2StateChangeString(i))
+	a := strings.Split(cmd.Name, ".")
+	req.cmd = a[len(a)-1]
+	req.args = args
+	if err := cmd.Validate(req); err != nil {
+		s.Invalidf("%v", err)
+		return false
 	}
-}
-
-func (s *Session) doEC2Command(req PostRequest) {
-	cli, err := NewEC2Client()
-	if err != nil {
-		s.Logf("NewEC2Client: %v", err)
-		return
+	if !s.authorize(cmd, req) {
+		return false
 	}
-	switch req.cmd {
-	case "vpcs":
-		vpcs, err := cli.DescribeVpcs()
-		if err != nil {
-			s.Logf("DescribeVpcs: %v", err)
-			return
-		}
-		for _, vpc := range vpcs {
-			s.Logf("%s", EC2VpcString(vpc))
-		}
-	case "subnets":
-		cli.VpcId = nil
-		if req.VpcId != "" {
-			s.Logf("VpcId: %s", req.VpcId)
-			cli.VpcId = &req.VpcId
-		}
-		subnets, err := cli.DescribeSubnets()
-		if err != nil {
-			s.Logf("DescribeSubnets: %v", err)
-			return
-		}
-		for _, subnet := range subnets {
-			s.Logf("%s", EC2SubnetString(subnet))
-		}
-	case "sgs":
-		cli.VpcId = nil
-		if req.VpcId != "" {
-			s.Logf("VpcId: %s", req.VpcId)
-			cli.VpcId = &req.VpcId
-		}
-		sgs, err := cli.DescribeSecurityGroups()
-		if err != nil {
-			s.Logf("DescribeSecurityGroups: %v", err)
-			return
-		}
-		for _, sg := range sgs {
-			s.Logf("%s", EC2SecurityGroupString(sg))
-		}
-	case "nics":
-		cli.VpcId = nil
-		if req.VpcId != "" {
-			s.Logf("VpcId: %s", req.VpcId)
-			cli.VpcId = &req.VpcId
-		}
-		nics, err := cli.DescribeNetworkInterfaces(req.Nics)
-		if err != nil {
-			s.Logf("DescribeNetworkInterfaces: %v", err)
-			return
-		}
-		for _, nic := range nics {
-			s.Logf("%s", EC2NetworkInterfaceString(nic))
-		}
-	case "vols":
-		vols, err := cli.DescribeVolumes()
-		if err != nil {
-			s.Logf("DescribeVolumes: %v", err)
-			return
-		}
-		for _, vol := range vols {
-			s.Logf("%s", EC2VolumeString(vol))
-		}
-	case "images":
-		arch := "x86_64"
-		if req.Arch != nil {
-			arch = *req.Arch
-		}
-		var image ec2types.Image
-		var err error
-		if req.Name != nil && req.Owner != nil {
-			image, err = cli.GetImage(*req.Name, *req.Owner, arch)
-		} else {
-			distro := "amazon"
-			if req.Distro != nil {
-				distro = *req.Distro
-			}
-			image, err = cli.GetDistroImage(distro, arch)
-		}
-		if err != nil {
-			s.Logf("GetImage: %v", err)
-			return
-		}
-		s.Logf("%s", EC2ImageString(image))
-	case "describe", "instances":
-		cli.VpcId = nil
-		if req.VpcId != "" {
-			s.Logf("VpcId: %s", req.VpcId)
-			cli.VpcId = &req.VpcId
-		}
-		instances, err := cli.DescribeInstances()
-		if err != nil {
-			s.Logf("Describe: %v", err)
-			return
-		}
-		for _, inst := range instances {
-			s.Logf("%s", EC2InstanceString(inst))
-		}
-	case "spotrequest":
-		s.doEC2RequestSpotInstances(cli, req)
-	case "run":
-		s.doEC2RunInstances(cli, req)
-	case "start":
-		ids, err := parseInstanceIds(req)
-		if err != nil {
-			s.Logf("start: %s", err)
-			return
-		}
-		instances, err := cli.StartInstances(ids)
-		if err != nil {
-			s.Logf("StartInstances: %v", err)
-			return
-		}
-		s.showInstancesState(instances)
-	case "stop":
-		ids, err := parseInstanceIds(req)
-		if err != nil {
-			s.Logf("stop: %s", err)
-			return
-		}
-		instances, err := cli.StopInstances(ids, req.Force)
-		if err != nil {
-			s.Logf("StopInstances: %v", err)
-			return
-		}
-		s.showInstancesState(instances)
-	case "terminate":
-		ids, err := parseInstanceIds(req)
-		if err != nil {
-			s.Logf("terminate: %s", err)
-			return
-		}
-		instances, err := cli.TerminateInstances(ids)
-		if err != nil {
-			s.Logf("TerminateInstances: %v", err)
-			return
-		}
-		s.showInstancesState(instances)
-	case "rename":
-		if req.InstanceId == nil {
-			s.Logf("no instanceid")
-			return
-		}
-		if req.Name == nil {
-			s.Logf("no name")
-			return
-		}
-		cli.InstanceIds = []string{*req.InstanceId}
-		cli.VpcId = nil
-		instances, err := cli.DescribeInstances()
-		if err != nil {
-			s.Logf("DescribeInstances: %v", err)
-			return
-		}
-		if len(instances) != 1 {
-			s.Logf("multiple instances")
-			return
-		}
-		prevname := EC2InstanceName(instances[0])
-		rename := map[string]string{
-			"Name": *req.Name,
-		}
-		cli.SetTags(instances[0], rename)
-		s.Logf("%s: rename %s to %s", *instances[0].InstanceId, prevname, *req.Name)
-	case "createvolume":
-		if req.AvailabilityZone == nil {
-			s.Logf("no az")
-			return
-		}
-		if req.VolumeSize == nil {
-			s.Logf("no size")
-			return
-		}
-		volumeid, err := cli.CreateVolume(*req.AvailabilityZone, *req.VolumeSize)
-		if err != nil {
-			s.Logf("CreateVolume: %v", err)
-			return
-		}
-		s.Logf("Volume %s has been created", volumeid)
-		if req.Name != nil {
-			cli.CreateTags(volumeid, map[string]string{"Name": *req.Name})
-		}
-		return
-	case "deletevolume":
-		if req.VolumeId == nil {
-			s.Logf("no volumeid")
-			return
-		}
-		err := cli.DeleteVolume(*req.VolumeId)
-		if err != nil {
-			s.Logf("DeleteVolume: %v", err)
-			return
-		}
-		return
-	case "attachvolume":
-		if req.VolumeId == nil {
-			s.Logf("no volumeid")
-			return
-		}
-		if req.InstanceId == nil {
-			s.Logf("no instanceid")
-			return
-		}
-		volumeId := *req.VolumeId
-		instanceId := *req.InstanceId
-		device := "/dev/sdf"
-		if req.Device != nil {
-			device = *req.Device
-		}
-		err := cli.AttachVolume(volumeId, instanceId, device)
-		if err != nil {
-			s.Logf("AttachVolume: %v", err)
-			return
-		}
-	case "detachvolume":
-		if req.VolumeId == nil {
-			s.Logf("no volumeid")
-			return
-		}
-		volumeId := *req.VolumeId
-		err := cli.DetachVolume(volumeId)
-		if err != nil {
-			s.Logf("DetachVolume: %v", err)
-			return
-		}
-	case "change":
-		if len(req.args) == 0 {
-			s.Logf("need change attributename")
-			return
-		}
-		if req.args[0] != "type" {
-			s.Logf("support only type")
-			return
-		}
-		if req.InstanceId == nil {
-			s.Logf("no instanceid")
-			return
-		}
-		if req.InstanceType == "" {
-			s.Logf("no instancetype")
-			return
-		}
-		if err := cli.ModifyInstanceAttributeType(*req.InstanceId, req.InstanceType); err != nil {
-			s.Logf("ModifyInstanceAttributeType: %v", err)
-			return
-		}
-		s.Logf("instance type has been modified")
-	}
-}
-
-func (s *Session) doECSCommand(req PostRequest) {
-	cli, err := NewECSClient()
-	if err != nil {
-		s.Logf("NewECSClient: %v", err)
-		return
-	}
-	switch req.cmd {
-	case "clusters":
-		// DescribeClusters API requires cluster names or ARNs
-		// first get ARNs with ListClusters
-		s.Logf("list clusters")
-		arns, err := cli.ListClusters()
-		if err != nil {
-			s.Logf("ListClusters: %v", err)
-			return
-		}
-		for _, arn := range arns {
-			s.Logf("%s", arn)
-		}
-		// then, call DescribeClusters with ARNs
-		s.Logf("describe clusters")
-		cls, err := cli.DescribeClusters(arns)
-		if err != nil {
-			s.Logf("DescribeClusters: %v", err)
-			return
-		}
-		for _, c := range cls {
-			s.Logf("%s", *c.ClusterName)
-		}
-	case "taskdefs":
-		arns, err := cli.ListTaskDefinitions()
-		if err != nil {
-			s.Logf("ListTaskDefinitions: %v", err)
-			return
-		}
-		for _, arn := range arns {
-			s.Logf("%s", arn)
-		}
-	case "taskdef":
-		family := req.Family
-		if family == nil {
-			// old compatibility
-			if req.ARN == nil {
-				s.Logf("need family or arn")
-				return
-			}
-			s.Logf("please use family")
-			family = req.ARN
-		}
-		taskdefp, err := cli.DescribeTaskDefinition(*family)
-		if err != nil {
-			s.Logf("DescribeTaskDefinition: %v", err)
-			return
-		}
-		if taskdefp == nil {
-			s.Logf("TaskDefinition nil\n")
-			return
-		}
-		s.Logf("%s:%d", *taskdefp.Family, taskdefp.Revision)
-		j, err := json.Marshal(taskdefp)
-		if err != nil {
-			s.Logf("Marshal: %v", err)
-			return
-		}
-		s.Logf("taskdef: %s", j)
-	case "regtaskdef":
-		if req.Family == nil {
-			s.Logf("need family")
-			return
-		}
-		if req.ExecRole == nil {
-			s.Logf("need execrole")
-			return
-		}
-		if req.Cpu == nil {
-			s.Logf("need cpu")
-			return
-		}
-		if req.Memory == nil {
-			s.Logf("need memory")
-			return
-		}
-		cname := "ubuntu"
-		if req.Name != nil {
-			cname = *req.Name
-		}
-		cimage := "ubuntu:latest"
-		if req.Image != nil {
-			cimage = *req.Image
-		}
-		taskdef, err := cli.RegisterTaskDefinition(*req.Family, *req.Cpu, *req.Memory, *req.ExecRole, cname, cimage)
-		if err != nil {
-			s.Logf("RegisterTaskDefinition: %v", err)
-			return
-		}
-		s.Logf("%+v", taskdef)
-	case "deregtaskdef":
-		if req.Family == nil {
-			s.Logf("need family")
-			return
-		}
-		taskdef, err := cli.DeregisterTaskDefinition(*req.Family)
-		if err != nil {
-			s.Logf("DeregisterTaskDefinition: %v", err)
-			return
-		}
-		s.Logf("%+v", taskdef)
-	case "tasks", "tasksraw":
-		if req.Cluster == nil {
-			s.Logf("need cluster")
-			return
-		}
-		taskarns, err := cli.ListTasks(*req.Cluster)
-		if err != nil {
-			s.Logf("ListTasks: %v", err)
-			return
-		}
-		if len(taskarns) == 0 {
-			s.Logf("no tasks")
-			return
-		}
-		tasks, err := cli.DescribeTasks(taskarns, *req.Cluster)
-		if err != nil {
-			s.Logf("DescribeTasks: %v", err)
-			return
-		}
-		for _, t := range tasks {
-			s.Logf("%s", *t.TaskArn)
-			s.Logf(" def: %s", *t.TaskDefinitionArn)
-			s.Logf(" status: %s", *t.LastStatus)
-			s.Logf(" group: %s", *t.Group)
-			for _, a := range t.Attachments {
-				for _, kv := range a.Details {
-					s.Logf("  %s: %s", *kv.Name, *kv.Value)
-				}
-			}
-		}
-		if req.cmd == "tasksraw" {
-			raw, err := json.Marshal(tasks)
-			if err != nil {
-				s.Logf("Marshal: %v", err)
-				return
-			}
-			s.Logf("raw: %s", raw)
-		}
-	case "runtask":
-		var count int32 = 1
-		if req.Count != nil {
-			count = *req.Count
-			if count >= 10 {
-				s.Logf("count too large")
-				return
-			}
-		}
-		if req.ARN == nil {
-			s.Logf("need arn")
-			return
-		}
-		if req.Name == nil {
-			s.Logf("need name")
-			return
-		}
-		if req.Cluster == nil {
-			s.Logf("need cluster")
-			return
-		}
-		if req.SubnetId == nil {
-			s.Logf("need subnetid")
-			return
-		}
-		if req.SecurityGroupIds == nil {
-			s.Logf("need securitygroupids")
-			return
-		}
-		if req.ExecCommand == nil {
-			s.Logf("need execommand")
-			return
-		}
-		taskdefp, err := cli.DescribeTaskDefinition(*req.ARN)
-		if err != nil {
-			s.Logf("DescribeTaskDefinition: %v", err)
-			return
-		}
-		if taskdefp == nil {
-			s.Logf("TaskDefinition nil\n")
-			return
-		}
-		pubip := true
-		if req.AssociatePublicIp != nil {
-			pubip = *req.AssociatePublicIp
-		}
-		spot := len(req.args) > 0 && req.args[0] == "spot"
-		tasks, err := cli.RunTask(taskdefp, spot, count, req.Group, req.TaskRole, req.Cpu, req.Memory, *req.Name, *req.Cluster, *req.SubnetId, pubip, req.SecurityGroupIds, req.ExecCommand)
-		if err != nil {
-			s.Logf("RunTask: %v", err)
-			return
-		}
-		if req.Tags != nil {
-			s.Logf("TagResource: %v", req.Tags)
-			for _, task := range tasks {
-				arn := *task.TaskArn
-				err := cli.TagResource(arn, req.Tags)
-				if err != nil {
-					s.Logf("task:%s %v", arn, err)
-				}
-			}
-		}
-		for _, task := range tasks {
-			s.Logf("starting %s", *task.TaskArn)
-		}
-	case "stoptask":
-		if req.Cluster == nil {
-			s.Logf("need cluster")
-			return
-		}
-		arns := req.ARNs
-		if len(arns) == 0 {
-			if req.ARN == nil {
-				s.Logf("need arn")
-				return
-			}
-			arns = []string{*req.ARN}
-		}
-		for _, arn := range arns {
-			task, err := cli.StopTask(arn, *req.Cluster)
-			if err != nil {
-				s.Logf("StopTask: %v", err)
-				continue
-			}
-			s.Logf("stopping %s", *task.TaskArn)
-		}
-	case "exec":
-		if req.Cluster == nil {
-			s.Logf("need cluster")
-			return
-		}
-		if req.ExecCommand == nil {
-			s.Logf("need execommand")
-			return
-		}
-		cmd := strings.Join(req.ExecCommand, " ")
-		arns := req.ARNs
-		if len(arns) == 0 {
-			if req.ARN == nil {
-				s.Logf("need arn")
-				return
-			}
-			arns = []string{*req.ARN}
-		}
-		for _, arn := range arns {
-			s.Logf("exec %s on %s", cmd, arn)
-			err := cli.ExecuteCommand(arn, *req.Cluster, cmd)
-			if err != nil {
-				s.Logf("ExecuteCommand: %v", err)
-			}
-		}
-	case "tag":
-		if req.Tags == nil {
-			s.Logf("need tags")
-			return
-		}
-		arns := req.ARNs
-		if len(arns) == 0 {
-			if req.ARN == nil {
-				s.Logf("need arn")
-				return
-			}
-			arns = []string{*req.ARN}
-		}
-		for _, arn := range arns {
-			s.Logf("tags %v on %s", req.Tags, arn)
-			err := cli.TagResource(arn, req.Tags)
-			if err != nil {
-				s.Logf("TagResource: %v", err)
-			}
+	if req.DryRun && cmd.Mutating {
+		res.DryRun = true
+		s.dryRun(req)
+		if !cmd.NativeDryRun {
+			return true
 		}
 	}
-}
-
-func (s *Session) doS3Command(req PostRequest) {
-	switch req.cmd {
-	case "concat":
-		if req.Destination == "" || len(req.Sources) == 0 {
-			s.Logf("need destination and sources")
-			return
-		}
-		if err := s.Bucket.ConcatObjects(req.Destination, req.Sources); err != nil {
-			s.Logf("ConcatObjects: %v", err)
-			return
-		}
-		s.Logf("concat ok")
-	case "store":
-		if req.Destination == "" || len(req.Sources) == 0 {
-			s.Logf("need destination and sources")
-			return
+	res.sensitive = cmd.Sensitive
+	var idem *IdempotencyRecord
+	if req.IdempotencyKey != "" && !res.DryRun {
+		if idem = s.beginIdempotent(req, res); idem == nil {
+			return res.Status == StatusOK
 		}
-		if err := s.Bucket.StoreObject(req.Destination, req.Sources); err != nil {
-			s.Logf("StoreObject: %v", err)
-			return
-		}
-		s.Logf("stored")
 	}
-}
-
-func (s *Session) doLambdaCommand(req PostRequest) {
-	switch req.cmd {
-	case "update":
-		if req.Function == "" || req.Zipfile == "" {
-			s.Logf("need function and zipfile")
-			return
-		}
-		bucketname := os.Getenv("BUCKET_NAME")
-		if bucketname == "" {
-			s.Logf("no bucket")
-			return
-		}
-		if err := LambdaUpdateFunctionCode(req.Function, bucketname, req.Zipfile); err != nil {
-			s.Logf("LambdaUpdateFunctionCode: %v", err)
-			return
-		}
-		s.Logf("update ok")
+	if req.Fanout {
+		s.handleFanout(cmd, req)
+	} else {
+		cmd.Handler(s, req)
 	}
-}
-
-func (s *Session) doSTSCommand(req PostRequest) {
-	cli, err := NewSTSClient()
-	if err != nil {
-		s.Logf("NewSTSClient: %v", err)
-		return
+	if idem != nil {
+		s.finishIdempotent(idem, res)
 	}
-	switch req.cmd {
-	case "switch":
-		if req.ARN == nil {
-			s.Logf("need arn")
-			return
-		}
-		cred, err := cli.AssumeRole(*req.ARN)
-		if err != nil {
-			s.Logf("AssumeRole: %v", err)
-			return
-		}
-		s.Logf("%s %s %s", *cred.AccessKeyId, *cred.SecretAccessKey, *cred.SessionToken)
+	if res.Status != StatusOK && s.expired() {
+		s.timedOut(step)
 	}
+	return res.Status == StatusOK
 }
 
-func (s *Session) doExecCommand(req PostRequest) {
-	dir := req.Destination
-	if dir == "" {
-		dir = "/tmp"
+// requestParams returns the fields of the request except the common ones
+func requestParams(req PostRequest) map[string]interface{} {
+	params := map[string]interface{}{}
+	if b, err := json.Marshal(req); err == nil {
+		json.Unmarshal(b, &params)
 	}
-	switch req.cmd {
-	case "unzip":
-		if req.Zipfile == "" {
-			s.Logf("no zipfile")
-			return
-		}
-		obj, err := s.Bucket.Get(req.Zipfile)
-		if err != nil {
-			s.Logf("S3Get: %v", err)
-			return
-		}
-		if err := Unzip(obj, dir); err != nil {
-			s.Logf("Unzip: %v", err)
-			return
-		}
-		s.Logf("Unzip: ok")
-	case "files":
-		lines, err := ExecListFiles(dir)
-		if err != nil {
-			s.Logf("ListFiles: %v", err)
-			return
-		}
-		s.LogLines(lines)
-	case "concat":
-		if req.Destination == "" || len(req.Sources) == 0 {
-			s.Logf("need destination and sources")
-			return
-		}
-		if err := ExecConcat(req.Destination, req.Sources); err != nil {
-			s.Logf("ExecConcat: %v", err)
-			return
-		}
-		s.Logf("concat ok")
-	case "run":
-		if req.ExecCommand == nil {
-			s.Logf("no execcommand")
-			return
-		}
-		lines, err := ExecRun(req.ExecCommand)
-		if err != nil {
-			s.Logf("Run: %v", err)
-			return
-		}
-		s.LogLines(lines)
+	for _, key := range []string{"command", "id", "if", "dryrun", "response", "format", "requests", "idempotencykey", "columns"} {
+		delete(params, key)
 	}
+	return params
 }
 
-func (s *Session) handlePostRequest(req PostRequest) {
-	if req.Command != "" {
-		// parse
-		a := strings.Split(req.Command, ".")
-		if len(a) == 1 {
-			s.Logf("command parse error: %s", req.Command)
-			return
-		}
-		key := a[0]
-		req.cmd = a[1]
-		req.args = a[2:]
-		domap := map[string]func(PostRequest){
-			"ec2":    s.doEC2Command,
-			"ecs":    s.doECSCommand,
-			"s3":     s.doS3Command,
-			"lambda": s.doLambdaCommand,
-			"sts":    s.doSTSCommand,
-			"exec":   s.doExecCommand,
-		}
-		if f, ok := domap[key]; ok {
-			f(req)
-		}
-		return
-	}
-	for _, r := range req.Requests {
-		s.handlePostRequest(r)
-	}
+// dryRun reports the resolved parameters of the request
+func (s *Session) dryRun(req PostRequest) {
+	params := requestParams(req)
+	b, _ := json.Marshal(params)
+	s.Logf("dry-run: %s %s", req.Command, string(b))
+	s.SetData(params)
 }
 
 func (s *Session) handleJSONRequest(body []byte) {
 	var req PostRequest
-	err := json.Unmarshal(body, &req)
-	if err != nil {
-		s.Logf("Unmarshal: %v", err)
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		s.Invalidf("Decode: %v", err)
 		return
 	}
-	s.handlePostRequest(req)
-}
-
-func (s *Session) handleMultipartRequestSubpartS3(key string, obj []byte) {
-	if err := s.Bucket.Put(key, obj); err != nil {
-		s.Logf("S3Put: %v", err)
-		return
+	switch req.Response {
+	case "json":
+		s.JSON = true
+	case "text":
+		s.JSON = false
 	}
-}
-
-func (s *Session) handleMultipartRequestSubpartTMP(filename string, obj []byte) {
-	if err := os.WriteFile("/tmp/"+filename, obj, 0644); err != nil {
-		s.Logf("WriteFile: %v", err)
+	if req.Async {
+		s.startJob(req)
 		return
 	}
+	s.handlePostRequest(req)
 }
 
-func (s *Session) handleMultipartRequestSubpart(body []byte) {
-	// header and content
-	a := bytes.SplitN(body, []byte("\r\n\r\n"), 2)
-	if len(a) != 2 {
-		// no content
-		s.Logf("no content")
-		return
-	}
-	cdisp := ""
-	ctype := ""
-	for _, header := range strings.Split(string(a[0]), "\r\n") {
-		// use a again
-		a := strings.SplitN(header, ": ", 2)
-		if len(a) != 2 {
-			continue
-		}
-		key := strings.ToLower(a[0])
-		switch key {
-		case "content-disposition":
-			cdisp = a[1]
-		case "content-type":
-			ctype = a[1]
-		}
-	}
-	if cdisp == "" {
-		s.Logf("no Disposition")
-		return
-	}
-	s.Logf("type: %s, disp: %s", ctype, cdisp)
-	a_disp := strings.Split(cdisp, "; ")
-	if a_disp[0] != "form-data" {
-		s.Logf("unknown Disposition")
-		return
-	}
-	name := ""
-	filename := ""
-	for _, p := range a_disp[1:] {
-		// use a again
-		a := strings.Split(p, "=")
-		if len(a) != 2 {
-			continue
-		}
-		key := strings.ToLower(a[0])
-		val := a[1]
-		if val[0] == '"' && val[len(val)-1] == '"' {
-			val = val[1 : len(val)-1]
-		}
-		switch key {
-		case "name":
-			name = val
-		case "filename":
-			filename = val
-		}
-	}
-	s.Logf("name = %s, filename = %s", name, filename)
-	// put it in tmp
-	if filename == "" {
-		s.Logf("no filename")
+// handleEventRequest runs the request of an invocation without the Function URL
+func (s *Session) handleEventRequest(req PostRequest) {
+	policy, err := LoadPolicy(s.Bucket)
+	if err != nil {
+		s.Failf(http.StatusInternalServerError, "LoadPolicy: %v", err)
 		return
 	}
-	switch name {
-	case "file", "s3":
-		s.handleMultipartRequestSubpartS3("tmp/"+filename, a[1])
-	case "tmp":
-		s.handleMultipartRequestSubpartTMP(filename, a[1])
-	default:
-		s.Logf("unknown name = %s", name)
-	}
-}
-
-func (s *Session) handleMultipartRequest(boundary string, body []byte) {
-	for n, part := range bytes.Split(body, []byte(boundary)) {
-		s.Logf("part %d", n)
-		s.handleMultipartRequestSubpart(part)
-	}
+	s.Policy = policy
+	s.handlePostRequest(req)
 }
 
 func (s *Session) handle(req events.LambdaFunctionURLRequest) {
@@ -1072,65 +325,130 @@ func (s *Session) handle(req events.LambdaFunctionURLRequest) {
 		return
 	case "POST": // do nothing
 	default:
-		s.Logf("Unknown request")
-		return
-	}
-	// IP check
-	sourceip := req.RequestContext.HTTP.SourceIP
-	allowed := os.Getenv("ALLOWED_IPS")
-	deny := true
-	for _, ip := range strings.Split(allowed, ",") {
-		if sourceip == strings.TrimSpace(ip) {
-			deny = false
-			break
-		}
-	}
-	allowed_hosts := os.Getenv("ALLOWED_HOSTS")
-	for _, host := range strings.Split(allowed_hosts, ",") {
-		addr, err := net.ResolveIPAddr("ip4", host)
-		if err != nil {
-			continue
-		}
-		if sourceip == addr.String() {
-			deny = false
-			break
-		}
-	}
-	if deny {
-		s.Logf("SourceIP: %s is NOT allowed", sourceip)
+		s.Failf(http.StatusMethodNotAllowed, "Unknown request")
 		return
 	}
+	s.SourceIP = req.RequestContext.HTTP.SourceIP
 	rawbody := []byte(req.Body)
 	if req.IsBase64Encoded {
 		rawbody, _ = base64.StdEncoding.DecodeString(req.Body)
 	}
+	if !s.authenticate(req, rawbody) {
+		return
+	}
+	policy, err := LoadPolicy(s.Bucket)
+	if err != nil {
+		s.Failf(http.StatusInternalServerError, "LoadPolicy: %v", err)
+		return
+	}
+	s.Policy = policy
 	ctype, ok := req.Headers["content-type"]
 	if !ok {
-		s.Logf("No Content-Type")
+		s.Invalidf("No Content-Type")
 		return
 	}
-	if ctype == "application/json" {
-		s.handleJSONRequest(rawbody)
+	mediatype, params, err := mime.ParseMediaType(ctype)
+	if err != nil {
+		s.Invalidf("Content-Type: %v", err)
 		return
 	}
-	if strings.Index(ctype, "multipart/form-data; boundary=") == 0 {
-		boundary := strings.Split(ctype, "boundary=")[1]
-		s.handleMultipartRequest("\r\n--"+boundary, rawbody)
-		return
+	switch mediatype {
+	case "application/json":
+		s.handleJSONRequest(rawbody)
+	case "multipart/form-data":
+		s.handleMultipartRequest(params["boundary"], rawbody)
+	default:
+		s.Invalidf("Unknown Content-Type: %s", ctype)
 	}
-	s.Logf("Unknown Content-Type: %s", ctype)
 }
 
 // Invoke from Lambda URL
-func Handler(req events.LambdaFunctionURLRequest) (string, error) {
+func Handler(ctx context.Context, req events.LambdaFunctionURLRequest) (events.LambdaFunctionURLResponse, error) {
 	start := time.Now()
-	s := NewSession()
+	sctx, cancel := withDeadlineMargin(ctx)
+	defer cancel()
+	s := NewSession(sctx)
+	s.RequestId = req.RequestContext.RequestID
+	if strings.Contains(req.Headers["accept"], "application/json") {
+		s.JSON = true
+	}
 	s.Logf("start handler")
 	s.handle(req)
+	s.checkTimeout()
+	s.LogSummary()
+	s.Audit(ctx, start)
 	s.Logf("end handler (%v)", time.Since(start))
-	return strings.Join(s.Outputs, "\n") + "\n", nil
+	resp := events.LambdaFunctionURLResponse{
+		StatusCode: s.StatusCode(),
+		Headers: map[string]string{
+			"content-type":      "text/plain; charset=utf-8",
+			"x-toolbox-summary": s.Summary(),
+		},
+		Body: strings.Join(s.Outputs, "\n") + "\n",
+	}
+	if s.JSON {
+		resp.Headers["content-type"] = "application/json"
+		resp.Body = s.JSONResponse()
+	}
+	return resp, nil
+}
+
+// Dispatch routes the invocation payload to Handler, RunJob, RunScheduled, HandleSQS or HandleSNS
+func Dispatch(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	var probe struct {
+		Toolbox    string `json:"toolbox"`
+		JobId      string `json:"jobid"`
+		DetailType string `json:"detail-type"`
+		Records    []struct {
+			// eventSource in SQS and EventSource in SNS
+			EventSource string `json:"eventSource"`
+		} `json:"Records"`
+	}
+	json.Unmarshal(payload, &probe)
+	if len(probe.Records) > 0 {
+		switch probe.Records[0].EventSource {
+		case "aws:sqs":
+			var event events.SQSEvent
+			if err := json.Unmarshal(payload, &event); err != nil {
+				return nil, err
+			}
+			return HandleSQS(ctx, event)
+		case "aws:sns":
+			var event events.SNSEvent
+			if err := json.Unmarshal(payload, &event); err != nil {
+				return nil, err
+			}
+			return nil, HandleSNS(ctx, event)
+		}
+		return nil, fmt.Errorf("unknown event source: %s", probe.Records[0].EventSource)
+	}
+	if probe.DetailType != "" {
+		var event events.CloudWatchEvent
+		if err := json.Unmarshal(payload, &event); err != nil {
+			return nil, err
+		}
+		return nil, RunScheduled(ctx, event)
+	}
+	switch probe.Toolbox {
+	case "job":
+		return nil, RunJob(ctx, probe.JobId)
+	case "":
+	default:
+		return nil, fmt.Errorf("unknown toolbox event: %s", probe.Toolbox)
+	}
+	var req events.LambdaFunctionURLRequest
+	if err := json.Unmarshal(payload, &req); err != nil {
+		return nil, err
+	}
+	return Handler(ctx, req)
 }
 
 func main() {
-	lambda.Start(Handler)
+	listen := flag.String("listen", "", "serve HTTP on the address like :8080 instead of running in Lambda")
+	timeout := flag.Duration("timeout", 15*time.Minute, "deadline of a request in the listen mode")
+	flag.Parse()
+	if *listen != "" {
+		log.Fatal(Serve(*listen, *timeout))
+	}
+	lambda.Start(Dispatch)
 }
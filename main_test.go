@@ -0,0 +1,30 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// the fields of ltb are generated from PostRequest by go generate ./cmd/ltb
+func TestLtbFields(t *testing.T) {
+	src, err := os.ReadFile("cmd/ltb/fields.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	typ := reflect.TypeOf(PostRequest{})
+	for i := 0; i < typ.NumField(); i++ {
+		f := typ.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		switch name {
+		case "", "-", "command", "role", "requests":
+			continue
+		}
+		if !strings.Contains(string(src), strconv.Quote(name)+":") {
+			t.Errorf("%s is not in cmd/ltb/fields.go, run go generate ./cmd/ltb", name)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// the whole body of a Function URL request is up to 6MB
+const defaultMaxPartSize = 6 * 1024 * 1024
+
+// the form field which has a JSON request run after the uploads
+const requestPartName = "request"
+
+func maxPartSize() int64 {
+	if n, err := strconv.ParseInt(os.Getenv("MAX_PART_SIZE"), 10, 64); err == nil && n > 0 {
+		return n
+	}
+	return defaultMaxPartSize
+}
+
+// CheckFilename rejects names which can escape the upload directory
+func CheckFilename(name string) error {
+	if name == "" {
+		return fmt.Errorf("no filename")
+	}
+	if name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") {
+		return fmt.Errorf("bad filename %q", name)
+	}
+	return nil
+}
+
+// partFilename returns the form name and the filename from Content-Disposition
+// which can have filename* in RFC 2231 form
+func partFilename(part *multipart.Part) (string, string, error) {
+	cdisp := part.Header.Get("Content-Disposition")
+	if cdisp == "" {
+		return "", "", fmt.Errorf("no Disposition")
+	}
+	disp, params, err := mime.ParseMediaType(cdisp)
+	if err != nil {
+		return "", "", fmt.Errorf("Disposition: %v", err)
+	}
+	if disp != "form-data" {
+		return "", "", fmt.Errorf("unknown Disposition %s", disp)
+	}
+	return params["name"], params["filename"], nil
+}
+
+func (s *Session) handleMultipartRequest(boundary string, body []byte) {
+	if boundary == "" {
+		s.Invalidf("no boundary")
+		return
+	}
+	limit := maxPartSize()
+	reader := multipart.NewReader(bytes.NewReader(body), boundary)
+	var request []byte
+	failed := false
+	for n := 0; ; n++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.Invalidf("part %d: %v", n, err)
+			failed = true
+			break
+		}
+		if !s.handleMultipartPart(n, part, limit, &request) {
+			failed = true
+		}
+		part.Close()
+	}
+	if request == nil {
+		return
+	}
+	if failed {
+		s.Invalidf("request is not run because uploads failed")
+		return
+	}
+	s.handleJSONRequest(request)
+}
+
+// handleMultipartPart stores a file or keeps the request and returns false on failure
+func (s *Session) handleMultipartPart(n int, part *multipart.Part, limit int64, request *[]byte) bool {
+	name, filename, err := partFilename(part)
+	if err != nil {
+		s.Invalidf("part %d: %v", n, err)
+		return false
+	}
+	obj, err := io.ReadAll(io.LimitReader(part, limit+1))
+	if err != nil {
+		s.Invalidf("part %d: %v", n, err)
+		return false
+	}
+	if int64(len(obj)) > limit {
+		s.Invalidf("part %d: %s is larger than %d bytes", n, name, limit)
+		return false
+	}
+	s.Logf("part %d: name = %s, filename = %s, %d bytes", n, name, filename, len(obj))
+	if name == requestPartName && filename == "" {
+		*request = obj
+		return true
+	}
+	if err := CheckFilename(filename); err != nil {
+		s.Invalidf("part %d: %v", n, err)
+		return false
+	}
+	switch name {
+	case "file", "s3":
+		if s.Bucket == nil {
+			s.Failf(http.StatusInternalServerError, "no bucket")
+			return false
+		}
+		if err := s.Bucket.Put("tmp/"+filename, obj); err != nil {
+			s.Errorf("S3Put: %v", err)
+			return false
+		}
+	case "tmp":
+		if err := os.WriteFile("/tmp/"+filename, obj, 0644); err != nil {
+			s.Failf(http.StatusInternalServerError, "WriteFile: %v", err)
+			return false
+		}
+	default:
+		s.Invalidf("unknown name = %s", name)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,113 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+const testBoundary = "testboundary"
+
+type testPart struct {
+	disposition string
+	body        string
+}
+
+func multipartBody(parts ...testPart) []byte {
+	var b strings.Builder
+	for _, p := range parts {
+		fmt.Fprintf(&b, "--%s\r\nContent-Disposition: %s\r\n\r\n%s\r\n", testBoundary, p.disposition, p.body)
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", testBoundary)
+	return []byte(b.String())
+}
+
+func tmpFilename(t *testing.T, name string) string {
+	name = fmt.Sprintf("ltb-test-%d-%s", os.Getpid(), name)
+	t.Cleanup(func() { os.Remove("/tmp/" + name) })
+	return name
+}
+
+func TestMultipartFilename(t *testing.T) {
+	semi := tmpFilename(t, "a;b=c.txt")
+	ext := tmpFilename(t, "café.txt")
+	tests := []struct {
+		disposition string
+		filename    string
+	}{
+		{`form-data; name="tmp"; filename="` + semi + `"`, semi},
+		{`form-data; name="tmp"; filename*=UTF-8''` + strings.Replace(ext, "é", "%C3%A9", 1), ext},
+	}
+	for _, tt := range tests {
+		s := newTestSession()
+		s.handleMultipartRequest(testBoundary, multipartBody(testPart{tt.disposition, "data"}))
+		if code := s.StatusCode(); code != http.StatusOK {
+			t.Errorf("%s: code %d %v", tt.disposition, code, s.Outputs)
+			continue
+		}
+		b, err := os.ReadFile("/tmp/" + tt.filename)
+		if err != nil || string(b) != "data" {
+			t.Errorf("%s: %q %v", tt.filename, b, err)
+		}
+	}
+}
+
+func TestMultipartReject(t *testing.T) {
+	t.Setenv("MAX_PART_SIZE", "4")
+	ok := tmpFilename(t, "ok.txt")
+	tests := []struct {
+		name string
+		part testPart
+	}{
+		{"parent", testPart{`form-data; name="tmp"; filename="../etc/passwd"`, "data"}},
+		{"dotdot", testPart{`form-data; name="tmp"; filename=".."`, "data"}},
+		{"backslash", testPart{`form-data; name="tmp"; filename="..\\x"`, "data"}},
+		{"encoded", testPart{`form-data; name="tmp"; filename*=UTF-8''..%2Fx`, "data"}},
+		{"no filename", testPart{`form-data; name="tmp"`, "data"}},
+		{"unknown name", testPart{`form-data; name="other"; filename="` + ok + `"`, "data"}},
+		{"not form-data", testPart{`attachment; filename="` + ok + `"`, "data"}},
+		{"too large", testPart{`form-data; name="tmp"; filename="` + ok + `"`, "12345"}},
+	}
+	for _, tt := range tests {
+		s := newTestSession()
+		s.handleMultipartRequest(testBoundary, multipartBody(tt.part))
+		if code := s.StatusCode(); code != http.StatusBadRequest {
+			t.Errorf("%s: code %d", tt.name, code)
+		}
+	}
+	if _, err := os.Stat("/tmp/" + ok); err == nil {
+		t.Errorf("rejected part is stored")
+	}
+	// the limit is inclusive
+	s := newTestSession()
+	s.handleMultipartRequest(testBoundary, multipartBody(testPart{`form-data; name="tmp"; filename="` + ok + `"`, "1234"}))
+	if code := s.StatusCode(); code != http.StatusOK {
+		t.Errorf("4 bytes: code %d %v", code, s.Outputs)
+	}
+}
+
+func TestMultipartRequest(t *testing.T) {
+	name := tmpFilename(t, "req.txt")
+	request := testPart{`form-data; name="request"`, `{"command":"help"}`}
+	s := newTestSession()
+	s.handleMultipartRequest(testBoundary, multipartBody(
+		testPart{`form-data; name="tmp"; filename="` + name + `"`, "data"}, request))
+	if code := s.StatusCode(); code != http.StatusOK || len(s.Results) != 1 {
+		t.Errorf("code %d results %d %v", code, len(s.Results), s.Outputs)
+	}
+	// the request is not run after a failed upload
+	s = newTestSession()
+	s.handleMultipartRequest(testBoundary, multipartBody(
+		testPart{`form-data; name="tmp"; filename="../x"`, "data"}, request))
+	if code := s.StatusCode(); code != http.StatusBadRequest || len(s.Results) != 0 {
+		t.Errorf("code %d results %d", code, len(s.Results))
+	}
+	s = newTestSession()
+	s.handleMultipartRequest("", multipartBody(request))
+	if code := s.StatusCode(); code != http.StatusBadRequest {
+		t.Errorf("no boundary: code %d", code)
+	}
+}
@@ -0,0 +1,87 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	defaultConcurrency = 4
+	maxConcurrency     = 16
+)
+
+// fork creates a session for a sub-request running in a goroutine.
+// It has its own outputs and results which are merged by join.
+func (s *Session) fork() *Session {
+	return &Session{
+		Bucket:    s.Bucket,
+		Verbose:   s.Verbose,
+		JSON:      s.JSON,
+		RequestId: s.RequestId,
+		SourceIP:  s.SourceIP,
+		Identity:  s.Identity,
+		Policy:    s.Policy,
+		ctx:       s.ctx,
+		steps:     s.steps,
+		progress:  s.progress,
+		cancelled: s.cancelled,
+		quiet:     s.quiet,
+	}
+}
+
+func (s *Session) join(child *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, i := range child.secret {
+		s.secret = append(s.secret, len(s.Outputs)+i)
+	}
+	s.Outputs = append(s.Outputs, child.Outputs...)
+	s.Results = append(s.Results, child.Results...)
+	s.Errors = append(s.Errors, child.Errors...)
+	if s.code == 0 {
+		s.code = child.code
+	}
+	s.cancelled = s.cancelled || child.cancelled
+	if s.timeoutStep == 0 && child.timeoutStep > 0 {
+		s.timeoutStep = len(s.Results) - len(child.Results) + child.timeoutStep
+	}
+}
+
+// handleParallel runs the requests concurrently and returns false when any of them failed.
+// Outputs are grouped per request in the original order.
+func (s *Session) handleParallel(reqs []PostRequest, concurrency int, stopOnError bool) bool {
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	if concurrency > maxConcurrency {
+		concurrency = maxConcurrency
+	}
+	s.Logf("parallel: %d requests, concurrency %d", len(reqs), concurrency)
+	var failed int32
+	sem := make(chan struct{}, concurrency)
+	children := make([]*Session, len(reqs))
+	var wg sync.WaitGroup
+	for i, r := range reqs {
+		child := s.fork()
+		children[i] = child
+		wg.Add(1)
+		go func(r PostRequest) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			if stopOnError && atomic.LoadInt32(&failed) != 0 {
+				child.skip(r)
+				return
+			}
+			if !child.handlePostRequest(r) {
+				atomic.StoreInt32(&failed, 1)
+			}
+		}(r)
+	}
+	wg.Wait()
+	for _, child := range children {
+		s.join(child)
+	}
+	return failed == 0
+}
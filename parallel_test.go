@@ -0,0 +1,31 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"sync/atomic"
+	"testing"
+)
+
+func TestParallelFork(t *testing.T) {
+	s := newTestSession()
+	s.RequestId = "req-1"
+	s.SourceIP = "192.0.2.1"
+	s.quiet = true
+	var steps int32
+	s.progress = func() { atomic.AddInt32(&steps, 1) }
+	child := s.fork()
+	if child.RequestId != "req-1" || child.SourceIP != "192.0.2.1" || !child.quiet || child.progress == nil {
+		t.Errorf("child %+v", child)
+	}
+	if !s.handleParallel([]PostRequest{{Command: "help"}, {Command: "help"}}, 2, false) {
+		t.Fatalf("failed %v", s.Outputs)
+	}
+	if steps != 2 || len(s.Results) != 2 {
+		t.Errorf("progress %d results %d", steps, len(s.Results))
+	}
+	child.cancelled = true
+	s.join(child)
+	if !s.cancelled {
+		t.Errorf("cancelled child is not joined")
+	}
+}
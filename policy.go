@@ -0,0 +1,213 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Policy maps identities to the commands they can run.
+// Without a policy everyone who passes authentication can run everything.
+type Policy struct {
+	Rules []*PolicyRule `json:"rules"`
+}
+
+// PolicyRule allows the commands for the callers from sources or with keys.
+// Keys are signing keys, or method:key for the other callers like "sqs:jobs".
+// A rule without sources and keys applies to everyone.
+// Commands are names like "ec2.describe", prefixes like "ecs.*" or "*".
+// Tags restrict the EC2 resources the commands change to the ones which have the tags.
+type PolicyRule struct {
+	Name     string            `json:"name,omitempty"`
+	Sources  []string          `json:"sources,omitempty"`
+	Keys     []string          `json:"keys,omitempty"`
+	Commands []string          `json:"commands"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	nets     []*net.IPNet
+}
+
+// LoadPolicy reads the policy from POLICY or the object POLICY_KEY in the bucket.
+// It returns nil when neither is set.
+func LoadPolicy(bucket *Bucket) (*Policy, error) {
+	body := []byte(os.Getenv("POLICY"))
+	if key := os.Getenv("POLICY_KEY"); len(body) == 0 && key != "" {
+		if bucket == nil {
+			return nil, fmt.Errorf("no bucket for %s", key)
+		}
+		b, err := bucket.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		body = b
+	}
+	if len(body) == 0 {
+		return nil, nil
+	}
+	return ParsePolicy(body)
+}
+
+func ParsePolicy(body []byte) (*Policy, error) {
+	p := &Policy{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(p); err != nil {
+		return nil, err
+	}
+	for i, rule := range p.Rules {
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		for _, src := range rule.Sources {
+			ipnet, err := ParseIPNet(src)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", rule.Name, err)
+			}
+			rule.nets = append(rule.nets, ipnet)
+		}
+	}
+	return p, nil
+}
+
+// Applies reports the rule is for the caller
+func (r *PolicyRule) Applies(id *Identity) bool {
+	if id == nil {
+		return false
+	}
+	if len(r.nets) > 0 {
+		ip := net.ParseIP(id.SourceIP)
+		found := false
+		for _, ipnet := range r.nets {
+			if ip != nil && ipnet.Contains(ip) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(r.Keys) > 0 {
+		found := false
+		for _, key := range r.Keys {
+			if matchKey(key, id) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// matchKey matches a signing key like "ci" or "hmac:ci", or the key of another method
+// like "sqs:queue", "sns:topic" or "schedule:rule".
+// A queue named like a signing key does not get its rules.
+func matchKey(key string, id *Identity) bool {
+	method, name := "hmac", key
+	if a := strings.SplitN(key, ":", 2); len(a) == 2 {
+		method, name = a[0], a[1]
+	}
+	return id.Key != "" && id.Method == method && id.Key == name
+}
+
+// Allows reports the command matches one of the patterns
+func (r *PolicyRule) Allows(command string) bool {
+	for _, pattern := range r.Commands {
+		if MatchCommand(pattern, command) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchCommand matches "*", "ecs.*" or a command name which also matches its arguments
+func MatchCommand(pattern, command string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if strings.HasSuffix(pattern, ".*") {
+		return strings.HasPrefix(command, strings.TrimSuffix(pattern, "*"))
+	}
+	return command == pattern || strings.HasPrefix(command, pattern+".")
+}
+
+// authorize checks the policy before the command runs
+func (s *Session) authorize(cmd *Command, req PostRequest) bool {
+	if s.Policy == nil {
+		return true
+	}
+	var errs []string
+	for _, rule := range s.Policy.Rules {
+		if !rule.Applies(s.Identity) || !rule.Allows(req.Command) {
+			continue
+		}
+		err := s.checkPolicyTags(cmd, req, rule.Tags)
+		if err == nil {
+			return true
+		}
+		errs = append(errs, fmt.Sprintf("%s: %v", rule.Name, err))
+	}
+	if len(errs) == 0 {
+		s.Failf(http.StatusForbidden, "%s is not allowed for %v", req.Command, s.Identity)
+		return false
+	}
+	s.Failf(http.StatusForbidden, "%s is not allowed: %s", req.Command, strings.Join(errs, ", "))
+	return false
+}
+
+// checkPolicyTags checks the resources changed by the request have the tags.
+// A mutating command which accepts tags must set them.
+// A mutating command which can not be checked either way is denied.
+func (s *Session) checkPolicyTags(cmd *Command, req PostRequest, tags map[string]string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+	if cmd.Mutating && cmd.HasField("tags") {
+		if !hasTags(req.Tags, tags) {
+			return fmt.Errorf("tags %s are required", strings.Join(EC2TagList(tags), ","))
+		}
+	}
+	if cmd.Targets == nil {
+		if cmd.Mutating && !cmd.HasField("tags") {
+			return fmt.Errorf("%s can not be checked for tags", req.Command)
+		}
+		return nil
+	}
+	ids := cmd.Targets(req)
+	if len(ids) == 0 {
+		return nil
+	}
+	cfg, err := s.awsConfig(req)
+	if err != nil {
+		return err
+	}
+	cli := NewEC2Client(s.ctx, cfg)
+	resTags, err := cli.DescribeTags(ids)
+	if err != nil {
+		return err
+	}
+	sort.Strings(ids)
+	for _, id := range ids {
+		if !hasTags(resTags[id], tags) {
+			return fmt.Errorf("%s is not tagged %s", id, strings.Join(EC2TagList(tags), ","))
+		}
+	}
+	return nil
+}
+
+func hasTags(have, want map[string]string) bool {
+	for k, v := range want {
+		if have[k] != v {
+			return false
+		}
+	}
+	return true
+}
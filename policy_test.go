@@ -0,0 +1,136 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMatchCommand(t *testing.T) {
+	tests := []struct {
+		pattern string
+		command string
+		want    bool
+	}{
+		{"*", "ec2.describe", true},
+		{"*", "help", true},
+		{"ecs.*", "ecs.runtask", true},
+		{"ecs.*", "ecs", false},
+		{"ecs.*", "ecsx.runtask", false},
+		{"ec2.describe", "ec2.describe", true},
+		{"ec2.describe", "ec2.describe.instances", true},
+		{"ec2.describe", "ec2.describeinstances", false},
+		{"ec2.describe", "ec2.run", false},
+		{"help", "help.ec2", true},
+	}
+	for _, tt := range tests {
+		if got := MatchCommand(tt.pattern, tt.command); got != tt.want {
+			t.Errorf("MatchCommand(%s, %s) = %v", tt.pattern, tt.command, got)
+		}
+	}
+}
+
+func TestPolicyRuleApplies(t *testing.T) {
+	p, err := ParsePolicy([]byte(`{"rules":[
+		{"name":"all","commands":["*"]},
+		{"name":"office","sources":["192.0.2.0/24","2001:db8::/32"],"commands":["*"]},
+		{"name":"ci","keys":["ci"],"commands":["*"]},
+		{"name":"both","sources":["192.0.2.0/24"],"keys":["ci"],"commands":["*"]},
+		{"name":"events","keys":["sqs:ci","schedule:nightly","hmac:ops"],"commands":["*"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rules := map[string]*PolicyRule{}
+	for _, r := range p.Rules {
+		rules[r.Name] = r
+	}
+	tests := []struct {
+		rule string
+		id   *Identity
+		want bool
+	}{
+		{"all", &Identity{Method: "ip", SourceIP: "198.51.100.1"}, true},
+		{"all", nil, false},
+		{"office", &Identity{Method: "ip", SourceIP: "192.0.2.10"}, true},
+		{"office", &Identity{Method: "ip", SourceIP: "2001:db8::10"}, true},
+		{"office", &Identity{Method: "ip", SourceIP: "198.51.100.1"}, false},
+		{"office", &Identity{Method: "schedule", Key: "nightly"}, false},
+		{"ci", &Identity{Method: "hmac", SourceIP: "198.51.100.1", Key: "ci"}, true},
+		{"ci", &Identity{Method: "hmac", SourceIP: "198.51.100.1", Key: "default"}, false},
+		{"ci", &Identity{Method: "ip", SourceIP: "198.51.100.1"}, false},
+		// queues, topics and schedules named like a signing key
+		{"ci", &Identity{Method: "sqs", Key: "ci"}, false},
+		{"ci", &Identity{Method: "sns", Key: "ci"}, false},
+		{"ci", &Identity{Method: "schedule", Key: "ci"}, false},
+		{"events", &Identity{Method: "sqs", Key: "ci"}, true},
+		{"events", &Identity{Method: "sns", Key: "ci"}, false},
+		{"events", &Identity{Method: "hmac", Key: "ci"}, false},
+		{"events", &Identity{Method: "schedule", Key: "nightly"}, true},
+		{"events", &Identity{Method: "hmac", Key: "nightly"}, false},
+		{"events", &Identity{Method: "hmac", Key: "ops"}, true},
+		{"events", &Identity{Method: "sqs", Key: "ops"}, false},
+		{"both", &Identity{Method: "hmac", SourceIP: "192.0.2.1", Key: "ci"}, true},
+		{"both", &Identity{Method: "hmac", SourceIP: "198.51.100.1", Key: "ci"}, false},
+		{"both", &Identity{Method: "ip", SourceIP: "192.0.2.1"}, false},
+	}
+	for _, tt := range tests {
+		if got := rules[tt.rule].Applies(tt.id); got != tt.want {
+			t.Errorf("%s: Applies(%v) = %v", tt.rule, tt.id, got)
+		}
+	}
+	if _, err := ParsePolicy([]byte(`{"rules":[{"sources":["bad"],"commands":["*"]}]}`)); err == nil {
+		t.Errorf("bad source is parsed")
+	}
+	if _, err := ParsePolicy([]byte(`{"rules":[{"command":["*"]}]}`)); err == nil {
+		t.Errorf("unknown field is parsed")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	p, err := ParsePolicy([]byte(`{"rules":[
+		{"name":"ops","keys":["ops"],"commands":["*"]},
+		{"name":"office","sources":["192.0.2.0/24"],"commands":["help","ec2.describe"]},
+		{"name":"tagged","sources":["198.51.100.0/24"],"commands":["*"],"tags":{"team":"a"}}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ops := &Identity{Method: "hmac", SourceIP: "203.0.113.1", Key: "ops"}
+	office := &Identity{Method: "ip", SourceIP: "192.0.2.1"}
+	tagged := &Identity{Method: "ip", SourceIP: "198.51.100.1"}
+	other := &Identity{Method: "ip", SourceIP: "203.0.113.1"}
+	tests := []struct {
+		id   *Identity
+		req  PostRequest
+		want bool
+	}{
+		{ops, PostRequest{Command: "ec2.createvolume"}, true},
+		{office, PostRequest{Command: "help"}, true},
+		{office, PostRequest{Command: "ec2.describe.instances"}, true},
+		{office, PostRequest{Command: "ec2.run"}, false},
+		{other, PostRequest{Command: "help"}, false},
+		// commands which do not change anything are not checked
+		{tagged, PostRequest{Command: "ec2.describe"}, true},
+		{tagged, PostRequest{Command: "ec2.run", Tags: map[string]string{"team": "a", "name": "x"}}, true},
+		{tagged, PostRequest{Command: "ec2.run", Tags: map[string]string{"team": "b"}}, false},
+		{tagged, PostRequest{Command: "ec2.run"}, false},
+		// neither targets nor tags to check
+		{tagged, PostRequest{Command: "ec2.createvolume"}, false},
+		{tagged, PostRequest{Command: "s3.store"}, false},
+	}
+	for _, tt := range tests {
+		cmd, _ := LookupCommand(tt.req.Command)
+		if cmd == nil {
+			t.Fatalf("no command %s", tt.req.Command)
+		}
+		s := &Session{ctx: context.Background(), Policy: p, Identity: tt.id}
+		if got := s.authorize(cmd, tt.req); got != tt.want {
+			t.Errorf("%v %s: authorize = %v %v", tt.id, tt.req.Command, got, s.Errors)
+		}
+	}
+	s := &Session{ctx: context.Background(), Identity: other}
+	if !s.authorize(commands["ec2.run"], PostRequest{Command: "ec2.run"}) {
+		t.Errorf("denied without policy")
+	}
+}
@@ -0,0 +1,166 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// QueuedRequest is the body of an SQS or SNS message
+type QueuedRequest struct {
+	PostRequest
+	// SQS queue URL or ARN, or SNS topic ARN which receives the response
+	ReplyTo string `json:"replyto,omitempty"`
+}
+
+// QueuedReply is the message sent to the reply destination
+type QueuedReply struct {
+	MessageId string          `json:"messageid"`
+	Code      int             `json:"code"`
+	Summary   string          `json:"summary"`
+	Response  json.RawMessage `json:"response"`
+}
+
+// arnName returns the last part of the ARN like the queue or topic name
+func arnName(arn string) string {
+	return arn[strings.LastIndex(arn, ":")+1:]
+}
+
+// runMessage runs the request in the message with its own session
+// and returns true when the message should be retried
+func runMessage(ctx, sctx context.Context, id *Identity, msgid, body string) bool {
+	start := time.Now()
+	s := NewSession(sctx)
+	s.RequestId = msgid
+	s.Identity = id
+	s.Logf("start %s message %s", id.Method, msgid)
+	var req QueuedRequest
+	dec := json.NewDecoder(strings.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		s.Invalidf("Decode: %v", err)
+	} else if req.Async {
+		s.Invalidf("async is not for %s messages", id.Method)
+	} else {
+		s.handleEventRequest(req.PostRequest)
+	}
+	s.checkTimeout()
+	s.LogSummary()
+	s.Audit(ctx, start)
+	s.Logf("end %s message %s (%v)", id.Method, msgid, time.Since(start))
+	retry := s.retryable()
+	// the output goes to CloudWatch Logs
+	fmt.Println(strings.Join(s.RedactedOutputs(), "\n"))
+	if req.ReplyTo != "" {
+		if err := s.reply(ctx, req.ReplyTo, msgid); err != nil {
+			fmt.Printf("reply to %s: %v\n", req.ReplyTo, err)
+		}
+	}
+	return retry
+}
+
+// retryable reports the message can succeed when it runs again.
+// Bad requests and denied ones fail again, timeouts and AWS failures may not.
+// A retry runs the whole request again, so it must not repeat a change
+// unless the step has an idempotency key.
+func (s *Session) retryable() bool {
+	code := s.StatusCode()
+	if code == http.StatusOK {
+		return false
+	}
+	if code < 500 {
+		s.Logf("failed with %d, not retried", code)
+		return false
+	}
+	for _, r := range s.Results {
+		if r.Status != StatusOK || r.DryRun || r.req.IdempotencyKey != "" {
+			continue
+		}
+		if cmd, _ := LookupCommand(r.Command); cmd != nil && cmd.Mutating {
+			s.Logf("failed with %d, not retried because %s succeeded", code, r.Command)
+			return false
+		}
+	}
+	return true
+}
+
+// reply sends the response to the SQS queue or the SNS topic
+func (s *Session) reply(ctx context.Context, dest, msgid string) error {
+	reply := QueuedReply{
+		MessageId: msgid,
+		Code:      s.StatusCode(),
+		Summary:   s.Summary(),
+		Response:  json.RawMessage(s.RedactedJSONResponse()),
+	}
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(reply); err != nil {
+		return err
+	}
+	cfg, err := LoadAWSConfig(ctx, "")
+	if err != nil {
+		return err
+	}
+	body := buf.String()
+	switch {
+	case strings.HasPrefix(dest, "https://"):
+		return NewSQSClient(ctx, cfg).SendMessage(dest, body, msgid)
+	case strings.HasPrefix(dest, "arn:aws:sqs:"):
+		// arn:aws:sqs:region:account:name
+		a := strings.Split(dest, ":")
+		if len(a) != 6 {
+			return fmt.Errorf("bad queue arn")
+		}
+		url := fmt.Sprintf("https://sqs.%s.amazonaws.com/%s/%s", a[3], a[4], a[5])
+		return NewSQSClient(ctx, cfg).SendMessage(url, body, msgid)
+	case strings.HasPrefix(dest, "arn:aws:sns:"):
+		return NewSNSClient(ctx, cfg).Publish(dest, body)
+	}
+	return fmt.Errorf("unknown destination")
+}
+
+// HandleSQS runs the messages and returns the ones which failed temporarily to the queue
+func HandleSQS(ctx context.Context, event events.SQSEvent) (events.SQSEventResponse, error) {
+	sctx, cancel := withDeadlineMargin(ctx)
+	defer cancel()
+	resp := events.SQSEventResponse{}
+	for _, msg := range event.Records {
+		if sctx.Err() != nil || runMessage(ctx, sctx, &Identity{
+			Method: "sqs",
+			Source: msg.EventSourceARN,
+			Key:    arnName(msg.EventSourceARN),
+		}, msg.MessageId, msg.Body) {
+			resp.BatchItemFailures = append(resp.BatchItemFailures, events.SQSBatchItemFailure{
+				ItemIdentifier: msg.MessageId,
+			})
+		}
+	}
+	return resp, nil
+}
+
+// HandleSNS runs the messages, the invocation fails when one of them failed temporarily
+func HandleSNS(ctx context.Context, event events.SNSEvent) error {
+	sctx, cancel := withDeadlineMargin(ctx)
+	defer cancel()
+	failed := []string{}
+	for _, rec := range event.Records {
+		msg := rec.SNS
+		if sctx.Err() != nil || runMessage(ctx, sctx, &Identity{
+			Method: "sns",
+			Source: msg.TopicArn,
+			Key:    arnName(msg.TopicArn),
+		}, msg.MessageID, msg.Message) {
+			failed = append(failed, msg.MessageID)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed messages: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
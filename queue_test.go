@@ -0,0 +1,32 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestRetryable(t *testing.T) {
+	failed := &Result{Command: "ec2.start", Status: StatusError, Code: http.StatusBadGateway}
+	tests := []struct {
+		name    string
+		results []*Result
+		want    bool
+	}{
+		{"ok", []*Result{{Command: "ec2.run", Status: StatusOK}}, false},
+		{"aws failure", []*Result{{Command: "ec2.describe", Status: StatusOK}, failed}, true},
+		{"timeout", []*Result{{Command: "ec2.describe", Status: StatusError, Code: http.StatusGatewayTimeout}}, true},
+		{"bad request", []*Result{{Command: "ec2.start", Status: StatusError, Code: http.StatusBadRequest}}, false},
+		{"denied", []*Result{{Command: "ec2.start", Status: StatusError, Code: http.StatusForbidden}}, false},
+		{"launched", []*Result{{Command: "ec2.run", Status: StatusOK}, failed}, false},
+		{"idempotent", []*Result{{Command: "ec2.run", Status: StatusOK, req: PostRequest{IdempotencyKey: "k"}}, failed}, true},
+		{"dry-run", []*Result{{Command: "ec2.run", Status: StatusOK, DryRun: true}, failed}, true},
+		{"rolled back", []*Result{{Command: "ec2.run", Status: StatusRolledBack}, failed}, true},
+	}
+	for _, tt := range tests {
+		s := &Session{Results: tt.results}
+		if got := s.retryable(); got != tt.want {
+			t.Errorf("%s: retryable = %v %v", tt.name, got, s.Outputs)
+		}
+	}
+}
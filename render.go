@@ -0,0 +1,276 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
+)
+
+// output formats of the items, text is the String form of each item
+var formats = []string{"text", "table", "csv", "json", "yaml"}
+
+func isTextFormat(format string) bool {
+	return format == "" || format == "text"
+}
+
+// checkFormat checks the format and the columns which are not for the text format
+func checkFormat(format string, columns []string) error {
+	if len(columns) > 0 && isTextFormat(format) {
+		return fmt.Errorf("columns need a format other than text")
+	}
+	if format == "" {
+		return nil
+	}
+	for _, f := range formats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown format %s (formats: %s)", format, strings.Join(formats, ", "))
+}
+
+// columnsOf returns the json names of the fields of the items in the struct order.
+// The columns of a struct slice are known without items.
+func columnsOf(items interface{}) []string {
+	v := reflect.ValueOf(items)
+	if v.Kind() != reflect.Slice {
+		return nil
+	}
+	t := v.Type().Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() == reflect.Interface {
+		if v.Len() == 0 {
+			return nil
+		}
+		e := v.Index(0)
+		for e.Kind() == reflect.Ptr || e.Kind() == reflect.Interface {
+			e = e.Elem()
+		}
+		t = e.Type()
+	}
+	columns := []string{}
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if f.IsExported() && name != "-" {
+				if name == "" {
+					name = f.Name
+				}
+				columns = append(columns, name)
+			}
+		}
+	case reflect.Map:
+		seen := map[string]bool{}
+		for _, row := range itemRows(items) {
+			for k := range row {
+				if !seen[k] {
+					seen[k] = true
+					columns = append(columns, k)
+				}
+			}
+		}
+		if len(columns) == 0 {
+			return nil
+		}
+		sort.Strings(columns)
+	}
+	return columns
+}
+
+// itemRows converts the items to maps by the json names
+func itemRows(items interface{}) []map[string]interface{} {
+	rows := []map[string]interface{}{}
+	b, err := json.Marshal(items)
+	if err != nil {
+		return rows
+	}
+	var list []interface{}
+	if json.Unmarshal(b, &list) != nil {
+		return rows
+	}
+	for _, e := range list {
+		row, ok := e.(map[string]interface{})
+		if !ok {
+			row = map[string]interface{}{"value": e}
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// cell formats a value in a row, tags are k=v,k=v and lists are comma separated
+func cell(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case map[string]interface{}:
+		keys := []string{}
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		kv := []string{}
+		for _, k := range keys {
+			kv = append(kv, k+"="+cell(v[k]))
+		}
+		return strings.Join(kv, ",")
+	case []interface{}:
+		list := []string{}
+		for _, e := range v {
+			switch e.(type) {
+			case map[string]interface{}, []interface{}:
+				b, _ := json.Marshal(e)
+				list = append(list, string(b))
+			default:
+				list = append(list, cell(e))
+			}
+		}
+		return strings.Join(list, ",")
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+// selectColumns checks the requested columns are in the items
+func selectColumns(available, requested []string) ([]string, error) {
+	if len(requested) == 0 {
+		return available, nil
+	}
+	known := map[string]bool{}
+	for _, c := range available {
+		known[c] = true
+	}
+	unknown := []string{}
+	for _, c := range requested {
+		if !known[c] {
+			unknown = append(unknown, c)
+		}
+	}
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("unknown columns %s (columns: %s)", strings.Join(unknown, ", "), strings.Join(available, ", "))
+	}
+	return requested, nil
+}
+
+// RenderItems returns the lines of the items in the format with the columns
+func RenderItems(items interface{}, format string, columns []string) ([]string, error) {
+	rows := itemRows(items)
+	buf := &bytes.Buffer{}
+	switch format {
+	case "table":
+		tw := tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, strings.ToUpper(strings.Join(columns, "\t")))
+		for _, row := range rows {
+			cells := []string{}
+			for _, c := range columns {
+				// tabwriter breaks cells at tabs and newlines
+				cells = append(cells, strings.NewReplacer("\t", " ", "\n", " ").Replace(cell(row[c])))
+			}
+			fmt.Fprintln(tw, strings.Join(cells, "\t"))
+		}
+		tw.Flush()
+	case "csv":
+		w := csv.NewWriter(buf)
+		w.Write(columns)
+		for _, row := range rows {
+			cells := []string{}
+			for _, c := range columns {
+				cells = append(cells, cell(row[c]))
+			}
+			w.Write(cells)
+		}
+		w.Flush()
+		if err := w.Error(); err != nil {
+			return nil, err
+		}
+	case "json":
+		// a line for each item with the columns in order
+		for _, row := range rows {
+			fields := []string{}
+			for _, c := range columns {
+				k, _ := json.Marshal(c)
+				v, _ := json.Marshal(row[c])
+				fields = append(fields, string(k)+":"+string(v))
+			}
+			fmt.Fprintf(buf, "{%s}\n", strings.Join(fields, ","))
+		}
+	case "yaml":
+		doc := &yaml.Node{Kind: yaml.SequenceNode}
+		for _, row := range rows {
+			item := &yaml.Node{Kind: yaml.MappingNode}
+			for _, c := range columns {
+				val := &yaml.Node{}
+				if err := val.Encode(row[c]); err != nil {
+					return nil, err
+				}
+				item.Content = append(item.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: c}, val)
+			}
+			doc.Content = append(doc.Content, item)
+		}
+		enc := yaml.NewEncoder(buf)
+		enc.SetIndent(2)
+		if err := enc.Encode(doc); err != nil {
+			return nil, err
+		}
+		enc.Close()
+	default:
+		return nil, fmt.Errorf("unknown format %s", format)
+	}
+	return strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n"), nil
+}
+
+// renderItems logs the items in the format of the current request
+func (s *Session) renderItems(items interface{}, columns []string) {
+	var req PostRequest
+	if s.result != nil {
+		req = s.result.req
+	}
+	if isTextFormat(req.Format) {
+		v := reflect.ValueOf(items)
+		for i := 0; i < v.Len(); i++ {
+			s.Logf("%v", v.Index(i).Interface())
+		}
+		return
+	}
+	if columns == nil {
+		columns = columnsOf(items)
+	}
+	n := reflect.ValueOf(items).Len()
+	// the columns of map items are not known without items
+	if n == 0 && columns == nil {
+		return
+	}
+	columns, err := selectColumns(columns, req.Columns)
+	if err != nil {
+		s.Invalidf("%v", err)
+		return
+	}
+	if n == 0 {
+		return
+	}
+	lines, err := RenderItems(items, req.Format, columns)
+	if err != nil {
+		s.Failf(http.StatusInternalServerError, "render: %v", err)
+		return
+	}
+	s.LogLines(lines)
+}
@@ -0,0 +1,124 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type renderItem struct {
+	Id    string            `json:"id"`
+	Name  string            `json:"name,omitempty"`
+	Tags  map[string]string `json:"tags,omitempty"`
+	Ips   []string          `json:"ips,omitempty"`
+	Size  int               `json:"size"`
+	inner string
+}
+
+var testItems = []renderItem{
+	{Id: "i-1", Name: "web one", Tags: map[string]string{"b": "2", "a": "1"}, Ips: []string{"10.0.0.1", "10.0.0.2"}, Size: 8},
+	{Id: "i-2", Name: "db,main", Size: 100},
+}
+
+func TestRenderItems(t *testing.T) {
+	columns := columnsOf(testItems)
+	if want := []string{"id", "name", "tags", "ips", "size"}; !reflect.DeepEqual(columns, want) {
+		t.Fatalf("columns %v", columns)
+	}
+	tests := []struct {
+		format  string
+		columns []string
+		want    []string
+	}{
+		{"table", columns, []string{
+			"ID   NAME     TAGS     IPS                SIZE",
+			"i-1  web one  a=1,b=2  10.0.0.1,10.0.0.2  8",
+			"i-2  db,main                              100",
+		}},
+		{"csv", columns, []string{
+			"id,name,tags,ips,size",
+			`i-1,web one,"a=1,b=2","10.0.0.1,10.0.0.2",8`,
+			`i-2,"db,main",,,100`,
+		}},
+		{"json", columns, []string{
+			`{"id":"i-1","name":"web one","tags":{"a":"1","b":"2"},"ips":["10.0.0.1","10.0.0.2"],"size":8}`,
+			`{"id":"i-2","name":"db,main","tags":null,"ips":null,"size":100}`,
+		}},
+		{"yaml", columns, []string{
+			"- id: i-1",
+			"  name: web one",
+			"  tags:",
+			`    a: "1"`,
+			`    b: "2"`,
+			"  ips:",
+			"    - 10.0.0.1",
+			"    - 10.0.0.2",
+			"  size: 8",
+			"- id: i-2",
+			"  name: db,main",
+			"  tags: null",
+			"  ips: null",
+			"  size: 100",
+		}},
+		// selected columns in the order
+		{"table", []string{"size", "id"}, []string{
+			"SIZE  ID",
+			"8     i-1",
+			"100   i-2",
+		}},
+		{"csv", []string{"name"}, []string{"name", "web one", `"db,main"`}},
+		{"json", []string{"size", "id"}, []string{`{"size":8,"id":"i-1"}`, `{"size":100,"id":"i-2"}`}},
+		{"yaml", []string{"id"}, []string{"- id: i-1", "- id: i-2"}},
+	}
+	for _, tt := range tests {
+		got, err := RenderItems(testItems, tt.format, tt.columns)
+		if err != nil {
+			t.Errorf("%s: %v", tt.format, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %v:\n%s\nwant\n%s", tt.format, tt.columns, strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+		}
+	}
+	if _, err := RenderItems(testItems, "xml", columns); err == nil {
+		t.Errorf("unknown format is rendered")
+	}
+}
+
+func TestSelectColumns(t *testing.T) {
+	available := columnsOf([]*renderItem{})
+	if got, err := selectColumns(available, nil); err != nil || !reflect.DeepEqual(got, available) {
+		t.Errorf("all columns %v %v", got, err)
+	}
+	if got, err := selectColumns(available, []string{"size", "id"}); err != nil || !reflect.DeepEqual(got, []string{"size", "id"}) {
+		t.Errorf("selected %v %v", got, err)
+	}
+	if _, err := selectColumns(available, []string{"id", "inner", "Name"}); err == nil {
+		t.Errorf("unknown columns are selected")
+	}
+	if err := checkFormat("text", []string{"id"}); err == nil {
+		t.Errorf("columns with text")
+	}
+	if err := checkFormat("", []string{"id"}); err == nil {
+		t.Errorf("columns with the default format")
+	}
+	if err := checkFormat("csv", []string{"id"}); err != nil {
+		t.Errorf("columns with csv: %v", err)
+	}
+}
+
+func TestRenderEmptyItems(t *testing.T) {
+	s := newTestSession()
+	s.result = &Result{Status: StatusOK, req: PostRequest{Format: "table", Columns: []string{"nothing"}}}
+	s.Items([]renderItem{})
+	if s.result.Status != StatusError {
+		t.Errorf("unknown column is accepted without items")
+	}
+	s = newTestSession()
+	s.result = &Result{Status: StatusOK, req: PostRequest{Format: "table", Columns: []string{"id"}}}
+	s.Items([]renderItem{})
+	if s.result.Status != StatusOK || len(s.Outputs) != 0 {
+		t.Errorf("empty items %s %v", s.result.Status, s.Outputs)
+	}
+}
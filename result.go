@@ -0,0 +1,201 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	StatusOK         = "ok"
+	StatusError      = "error"
+	StatusSkipped    = "skipped"
+	StatusRolledBack = "rolledback"
+)
+
+// Result is the outcome of a sub-request
+type Result struct {
+	Id      string                 `json:"id,omitempty"`
+	Command string                 `json:"command"`
+	Status  string                 `json:"status"`
+	Code    int                    `json:"code"`
+	Error   string                 `json:"error,omitempty"`
+	DryRun  bool                   `json:"dryrun,omitempty"`
+	Replay  bool                   `json:"replay,omitempty"`
+	Undo    bool                   `json:"undo,omitempty"`
+	Data    interface{}            `json:"data,omitempty"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+	Logs    []string               `json:"logs"`
+	// resolved request for the audit log and the rollback
+	req       PostRequest
+	params    map[string]interface{}
+	sensitive bool
+}
+
+type Response struct {
+	Code    int       `json:"code"`
+	Summary string    `json:"summary"`
+	Results []*Result `json:"results"`
+	Errors  []string  `json:"errors,omitempty"`
+	Logs    []string  `json:"logs"`
+}
+
+// Failf logs the message and marks the current sub-request as failed with the status code
+func (s *Session) Failf(code int, f string, args ...interface{}) {
+	out := fmt.Sprintf(f, args...)
+	s.Logf("%s", out)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.result == nil {
+		s.Errors = append(s.Errors, out)
+		if s.code == 0 {
+			s.code = code
+		}
+		return
+	}
+	s.result.Status = StatusError
+	if s.result.Error == "" {
+		s.result.Error = out
+		s.result.Code = code
+	}
+}
+
+// Errorf is for AWS API failures
+func (s *Session) Errorf(f string, args ...interface{}) {
+	s.Failf(http.StatusBadGateway, f, args...)
+}
+
+// Invalidf is for parse and validation errors
+func (s *Session) Invalidf(f string, args ...interface{}) {
+	s.Failf(http.StatusBadRequest, f, args...)
+}
+
+func (s *Session) SetData(data interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.result != nil {
+		s.result.Data = data
+	}
+}
+
+// Items sets a slice as the result data and logs the elements in the format of the request
+func (s *Session) Items(items interface{}) {
+	s.SetData(items)
+	if s.quiet {
+		return
+	}
+	s.renderItems(items, nil)
+}
+
+// StatusCode returns the first error code or 200
+func (s *Session) StatusCode() int {
+	if s.code != 0 {
+		return s.code
+	}
+	for _, r := range s.Results {
+		if r.Status == StatusError {
+			return r.Code
+		}
+	}
+	return http.StatusOK
+}
+
+func (s *Session) Summary() string {
+	counts := map[string]int{}
+	for _, r := range s.Results {
+		counts[r.Status]++
+	}
+	return fmt.Sprintf("total=%d ok=%d error=%d skipped=%d rolledback=%d",
+		len(s.Results), counts[StatusOK], counts[StatusError],
+		counts[StatusSkipped], counts[StatusRolledBack])
+}
+
+// LogSummary shows which steps ran, failed or were skipped
+func (s *Session) LogSummary() {
+	if len(s.Results) == 0 {
+		return
+	}
+	s.Logf("summary: %s", s.Summary())
+	for i, r := range s.Results {
+		if r.DryRun {
+			s.Logf(" #%d %s: %s (dry-run)", i+1, r.Command, r.Status)
+			continue
+		}
+		if r.Undo {
+			s.Logf(" #%d %s: %s (rollback)", i+1, r.Command, r.Status)
+			continue
+		}
+		s.Logf(" #%d %s: %s", i+1, r.Command, r.Status)
+	}
+}
+
+func (s *Session) JSONResponse() string {
+	return s.jsonResponse(s.Results, s.Outputs)
+}
+
+// RedactedJSONResponse is the response without the secrets of sensitive commands to be stored or sent
+func (s *Session) RedactedJSONResponse() string {
+	s.mu.Lock()
+	results, outputs := s.redacted()
+	s.mu.Unlock()
+	return s.jsonResponse(results, outputs)
+}
+
+// RedactedOutputs is the log without the lines of sensitive commands
+func (s *Session) RedactedOutputs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, outputs := s.redacted()
+	return outputs
+}
+
+// redacted returns copies of the results without the data, outputs and logs of sensitive commands
+// and the log without their lines. s.mu must be held.
+func (s *Session) redacted() ([]*Result, []string) {
+	results := make([]*Result, len(s.Results))
+	for i, r := range s.Results {
+		results[i] = r
+		if !r.sensitive {
+			continue
+		}
+		c := *r
+		c.Data = nil
+		c.Outputs = nil
+		c.Logs = []string{}
+		results[i] = &c
+	}
+	if len(s.secret) == 0 {
+		return results, s.Outputs
+	}
+	// the same line from another command is kept
+	secret := map[int]bool{}
+	for _, i := range s.secret {
+		secret[i] = true
+	}
+	outputs := []string{}
+	for i, line := range s.Outputs {
+		if !secret[i] {
+			outputs = append(outputs, line)
+		}
+	}
+	return results, outputs
+}
+
+func (s *Session) jsonResponse(results []*Result, outputs []string) string {
+	resp := Response{
+		Code:    s.StatusCode(),
+		Summary: s.Summary(),
+		Results: results,
+		Errors:  s.Errors,
+		Logs:    outputs,
+	}
+	if resp.Results == nil {
+		resp.Results = []*Result{}
+	}
+	out, err := json.Marshal(resp)
+	if err != nil {
+		return fmt.Sprintf("{\"errors\":[%q]}", err.Error())
+	}
+	return string(out) + "\n"
+}
@@ -0,0 +1,63 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestRedacted(t *testing.T) {
+	s := &Session{}
+	s.Logf("start")
+	plain := &Result{Command: "help", Status: StatusOK, Data: "help", Outputs: map[string]interface{}{"x": "y"}}
+	s.Results = append(s.Results, plain)
+	s.result = plain
+	s.Logf("help line")
+	s.Logf("done")
+	secret := &Result{Command: "sts.switch", Status: StatusOK, sensitive: true,
+		Data: "credentials", Outputs: map[string]interface{}{"secretaccesskey": "s"}}
+	s.Results = append(s.Results, secret)
+	s.result = secret
+	s.Logf("secretaccesskey = s")
+	s.Logf("done")
+	s.result = nil
+	s.Logf("end")
+
+	results, outputs := s.redacted()
+	if results[0] != plain {
+		t.Errorf("plain result is copied")
+	}
+	r := results[1]
+	if r.Data != nil || r.Outputs != nil || len(r.Logs) != 0 || r.Status != StatusOK {
+		t.Errorf("sensitive result %+v", r)
+	}
+	if want := []string{"start", "help line", "done", "end"}; !reflect.DeepEqual(outputs, want) {
+		t.Errorf("outputs %v", outputs)
+	}
+	// the session keeps them for the caller
+	if secret.Data == nil || len(secret.Logs) != 2 || len(s.Outputs) != 6 {
+		t.Errorf("session is changed")
+	}
+	var resp Response
+	if err := json.Unmarshal([]byte(s.RedactedJSONResponse()), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Results[1].Data != nil || len(resp.Logs) != 4 {
+		t.Errorf("response %+v", resp)
+	}
+}
+
+func TestRedactedJoin(t *testing.T) {
+	s := &Session{}
+	s.Logf("parallel")
+	child := s.fork()
+	child.result = &Result{Command: "sts.switch", Status: StatusOK, sensitive: true}
+	child.Results = append(child.Results, child.result)
+	child.Logf("secretaccesskey = s")
+	s.join(child)
+	s.Logf("secretaccesskey = s")
+	if _, outputs := s.redacted(); !reflect.DeepEqual(outputs, []string{"parallel", "secretaccesskey = s"}) {
+		t.Errorf("outputs %v", outputs)
+	}
+}
@@ -4,31 +4,46 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 )
 
 type Bucket struct {
 	name   string
+	ctx    context.Context
 	client *s3.Client
 }
 
-func NewBucket(name string) (*Bucket, error) {
+func NewBucket(ctx context.Context, name string) (*Bucket, error) {
 	if name == "" {
 		return nil, fmt.Errorf("empty name")
 	}
-	cfg, err := config.LoadDefaultConfig(context.TODO())
+	cfg, err := LoadAWSConfig(ctx, "")
 	if err != nil {
 		return nil, err
 	}
-	bucket := &Bucket{
+	return NewBucketWithConfig(ctx, cfg, name), nil
+}
+
+func NewBucketWithConfig(ctx context.Context, cfg aws.Config, name string) *Bucket {
+	return &Bucket{
 		name:   name,
+		ctx:    ctx,
 		client: s3.NewFromConfig(cfg),
 	}
-	return bucket, nil
+}
+
+// WithContext returns the bucket which uses ctx for requests
+func (b *Bucket) WithContext(ctx context.Context) *Bucket {
+	nb := *b
+	nb.ctx = ctx
+	return &nb
 }
 
 func (b *Bucket) Put(key string, body []byte) error {
@@ -37,19 +52,78 @@ func (b *Bucket) Put(key string, body []byte) error {
 		Key:    &key,
 		Body:   bytes.NewBuffer(body),
 	}
-	_, err := b.client.PutObject(context.TODO(), input)
+	_, err := b.client.PutObject(b.ctx, input)
+	return err
+}
+
+// PutIf writes the object only when it is not changed since it had the ETag,
+// or only when it does not exist with an empty ETag
+func (b *Bucket) PutIf(key string, body []byte, etag string) error {
+	input := &s3.PutObjectInput{
+		Bucket: &b.name,
+		Key:    &key,
+		Body:   bytes.NewBuffer(body),
+	}
+	cond := smithyhttp.SetHeaderValue("If-None-Match", "*")
+	if etag != "" {
+		cond = smithyhttp.SetHeaderValue("If-Match", etag)
+	}
+	_, err := b.client.PutObject(b.ctx, input, s3.WithAPIOptions(cond))
 	return err
 }
 
+// IsPreconditionFailed reports PutIf failed because the object was written by another
+func IsPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.ErrorCode() {
+	case "PreconditionFailed", "ConditionalRequestConflict":
+		return true
+	}
+	return false
+}
+
 func (b *Bucket) Get(key string) ([]byte, error) {
+	body, _, err := b.GetWithETag(key)
+	return body, err
+}
+
+// GetWithETag returns the object and its ETag for PutIf
+func (b *Bucket) GetWithETag(key string) ([]byte, string, error) {
 	input := &s3.GetObjectInput{
 		Bucket: &b.name,
 		Key:    &key,
 	}
-	output, err := b.client.GetObject(context.TODO(), input)
+	output, err := b.client.GetObject(b.ctx, input)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer output.Body.Close()
-	return io.ReadAll(output.Body)
+	body, err := io.ReadAll(output.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, aws.ToString(output.ETag), nil
+}
+
+// List returns the keys under the prefix
+func (b *Bucket) List(prefix string) ([]string, error) {
+	input := &s3.ListObjectsV2Input{
+		Bucket: &b.name,
+		Prefix: &prefix,
+	}
+	keys := []string{}
+	paginator := s3.NewListObjectsV2Paginator(b.client, input)
+	for paginator.HasMorePages() {
+		output, err := paginator.NextPage(b.ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range output.Contents {
+			keys = append(keys, *obj.Key)
+		}
+	}
+	return keys, nil
 }
@@ -0,0 +1,67 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"fmt"
+	"net/http"
+)
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name:        "s3.concat",
+			Description: "concatenate objects into destination",
+			Required:    []string{"destination", "sources"},
+			Handler:     (*Session).doS3Concat,
+			Mutating:    true,
+		},
+		&Command{
+			Name:        "s3.store",
+			Description: "store files in /tmp under destination",
+			Required:    []string{"destination", "sources"},
+			Handler:     (*Session).doS3Store,
+			Mutating:    true,
+		},
+	)
+}
+
+// bucket returns the bucket accessed with the region, profile and role of the request
+func (s *Session) bucket(req PostRequest) (*Bucket, error) {
+	if s.Bucket == nil {
+		return nil, fmt.Errorf("no bucket")
+	}
+	if req.Region == "" && req.Profile == "" && req.Role == nil {
+		return s.Bucket, nil
+	}
+	cfg, err := s.awsConfig(req)
+	if err != nil {
+		return nil, err
+	}
+	return NewBucketWithConfig(s.ctx, cfg, s.Bucket.name), nil
+}
+
+func (s *Session) doS3Concat(req PostRequest) {
+	b, err := s.bucket(req)
+	if err != nil {
+		s.Failf(http.StatusInternalServerError, "bucket: %v", err)
+		return
+	}
+	if err := b.ConcatObjects(req.Destination, req.Sources); err != nil {
+		s.Errorf("ConcatObjects: %v", err)
+		return
+	}
+	s.Logf("concat ok")
+}
+
+func (s *Session) doS3Store(req PostRequest) {
+	b, err := s.bucket(req)
+	if err != nil {
+		s.Failf(http.StatusInternalServerError, "bucket: %v", err)
+		return
+	}
+	if err := b.StoreObject(req.Destination, req.Sources); err != nil {
+		s.Errorf("StoreObject: %v", err)
+		return
+	}
+	s.Logf("stored")
+}
@@ -0,0 +1,101 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+const (
+	// stored requests for the scheduled events
+	storedRequestPrefix   = "requests/"
+	scheduledOutputPrefix = "scheduled/"
+)
+
+// ScheduledDetail names a stored request instead of carrying it
+type ScheduledDetail struct {
+	Request string `json:"request"`
+}
+
+// ruleName returns the name of the EventBridge rule which sent the event
+func ruleName(event events.CloudWatchEvent) string {
+	for _, arn := range event.Resources {
+		if i := strings.LastIndex(arn, "/"); i >= 0 {
+			return arn[i+1:]
+		}
+	}
+	return event.Source
+}
+
+func (b *Bucket) GetStoredRequest(name string) ([]byte, error) {
+	if err := CheckFilename(name); err != nil {
+		return nil, err
+	}
+	return b.Get(storedRequestPrefix + name + ".json")
+}
+
+// scheduledRequest returns the request in the detail or the stored one it names
+func (s *Session) scheduledRequest(detail json.RawMessage) (PostRequest, error) {
+	var req PostRequest
+	var named ScheduledDetail
+	body := []byte(detail)
+	if json.Unmarshal(detail, &named) == nil && named.Request != "" {
+		if s.Bucket == nil {
+			return req, fmt.Errorf("no bucket for %s", named.Request)
+		}
+		b, err := s.Bucket.GetStoredRequest(named.Request)
+		if err != nil {
+			return req, fmt.Errorf("%s: %v", named.Request, err)
+		}
+		body = b
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&req); err != nil {
+		return req, fmt.Errorf("Decode: %v", err)
+	}
+	if req.Command == "" && len(req.Requests) == 0 {
+		return req, fmt.Errorf("no request in the event")
+	}
+	return req, nil
+}
+
+// RunScheduled runs the request of the EventBridge event and writes the output to the bucket
+func RunScheduled(ctx context.Context, event events.CloudWatchEvent) error {
+	start := time.Now()
+	sctx, cancel := withDeadlineMargin(ctx)
+	defer cancel()
+	s := NewSession(sctx)
+	s.RequestId = event.ID
+	s.Identity = &Identity{Method: "schedule", Source: event.Source, Key: ruleName(event)}
+	if len(event.Resources) > 0 {
+		s.Identity.Source = event.Resources[0]
+	}
+	s.Logf("start %s %s", event.DetailType, s.Identity.Key)
+	if req, err := s.scheduledRequest(event.Detail); err != nil {
+		s.Invalidf("%v", err)
+	} else {
+		s.handleEventRequest(req)
+	}
+	s.checkTimeout()
+	s.LogSummary()
+	s.Audit(ctx, start)
+	s.Logf("end %s (%v)", s.Identity.Key, time.Since(start))
+	if s.Bucket == nil {
+		fmt.Println(strings.Join(s.RedactedOutputs(), "\n"))
+		return nil
+	}
+	// the failure is in the output, retrying the event runs the commands again
+	key := scheduledOutputPrefix + start.UTC().Format("2006/01/02/150405") + "-" + s.Identity.Key + ".json"
+	if err := s.Bucket.WithContext(ctx).Put(key, []byte(s.RedactedJSONResponse())); err != nil {
+		fmt.Println(strings.Join(s.RedactedOutputs(), "\n"))
+		return fmt.Errorf("%s: %v", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+)
+
+type SecretsManagerClient struct {
+	ctx    context.Context
+	client *secretsmanager.Client
+}
+
+func NewSecretsManagerClient(ctx context.Context) (*SecretsManagerClient, error) {
+	cfg, err := LoadAWSConfig(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	client := &SecretsManagerClient{
+		ctx:    ctx,
+		client: secretsmanager.NewFromConfig(cfg),
+	}
+	return client, nil
+}
+
+func (cli *SecretsManagerClient) GetSecretString(id string) (string, error) {
+	input := &secretsmanager.GetSecretValueInput{
+		SecretId: &id,
+	}
+	output, err := cli.client.GetSecretValue(cli.ctx, input)
+	if err != nil {
+		return "", err
+	}
+	if output.SecretString == nil {
+		return "", fmt.Errorf("%s has no secret string", id)
+	}
+	return *output.SecretString, nil
+}
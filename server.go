@@ -0,0 +1,107 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// same as the payload limit of Lambda
+const maxBodySize = 6 * 1024 * 1024
+
+// NewFunctionURLRequest translates the HTTP request into the Lambda Function URL event
+func NewFunctionURLRequest(r *http.Request) (events.LambdaFunctionURLRequest, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodySize+1))
+	if err != nil {
+		return events.LambdaFunctionURLRequest{}, err
+	}
+	if len(body) > maxBodySize {
+		return events.LambdaFunctionURLRequest{}, fmt.Errorf("body is too large")
+	}
+	headers := map[string]string{}
+	for k, v := range r.Header {
+		headers[strings.ToLower(k)] = strings.Join(v, ",")
+	}
+	query := map[string]string{}
+	for k, v := range r.URL.Query() {
+		query[k] = strings.Join(v, ",")
+	}
+	sourceip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		sourceip = r.RemoteAddr
+	}
+	id := make([]byte, 16)
+	rand.Read(id)
+	now := time.Now()
+	req := events.LambdaFunctionURLRequest{
+		Version:               "2.0",
+		RawPath:               r.URL.EscapedPath(),
+		RawQueryString:        r.URL.RawQuery,
+		Headers:               headers,
+		QueryStringParameters: query,
+		RequestContext: events.LambdaFunctionURLRequestContext{
+			RequestID:  hex.EncodeToString(id),
+			DomainName: r.Host,
+			Time:       now.Format("02/Jan/2006:15:04:05 -0700"),
+			TimeEpoch:  now.UnixMilli(),
+			HTTP: events.LambdaFunctionURLRequestContextHTTPDescription{
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Protocol:  r.Proto,
+				SourceIP:  sourceip,
+				UserAgent: r.UserAgent(),
+			},
+		},
+	}
+	// Function URLs encode the body unless it is text
+	ctype := headers["content-type"]
+	if strings.HasPrefix(ctype, "text/") || strings.HasPrefix(ctype, "application/json") {
+		req.Body = string(body)
+	} else {
+		req.Body = base64.StdEncoding.EncodeToString(body)
+		req.IsBase64Encoded = true
+	}
+	return req, nil
+}
+
+// Serve runs Handler for HTTP requests on the address.
+// Each request has the timeout as the deadline like a Lambda invocation.
+func Serve(addr string, timeout time.Duration) error {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		req, err := NewFunctionURLRequest(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		resp, err := Handler(ctx, req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		body := []byte(resp.Body)
+		if resp.IsBase64Encoded {
+			body, _ = base64.StdEncoding.DecodeString(resp.Body)
+		}
+		for k, v := range resp.Headers {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(resp.StatusCode)
+		w.Write(body)
+		log.Printf("%s %s %s %d", req.RequestContext.HTTP.SourceIP, r.Method, r.URL.Path, resp.StatusCode)
+	}
+	log.Printf("listen on %s", addr)
+	return http.ListenAndServe(addr, http.HandlerFunc(handler))
+}
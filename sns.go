@@ -0,0 +1,30 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+type SNSClient struct {
+	ctx    context.Context
+	client *sns.Client
+}
+
+func NewSNSClient(ctx context.Context, cfg aws.Config) *SNSClient {
+	return &SNSClient{
+		ctx:    ctx,
+		client: sns.NewFromConfig(cfg),
+	}
+}
+
+func (cli *SNSClient) Publish(topic, message string) error {
+	input := &sns.PublishInput{
+		TopicArn: &topic,
+		Message:  &message,
+	}
+	_, err := cli.client.Publish(cli.ctx, input)
+	return err
+}
@@ -0,0 +1,37 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+)
+
+type SQSClient struct {
+	ctx    context.Context
+	client *sqs.Client
+}
+
+func NewSQSClient(ctx context.Context, cfg aws.Config) *SQSClient {
+	return &SQSClient{
+		ctx:    ctx,
+		client: sqs.NewFromConfig(cfg),
+	}
+}
+
+// SendMessage sends the body to the queue, the group and deduplication ids are set for FIFO queues
+func (cli *SQSClient) SendMessage(url, body, dedupid string) error {
+	input := &sqs.SendMessageInput{
+		QueueUrl:    &url,
+		MessageBody: &body,
+	}
+	if strings.HasSuffix(url, ".fifo") {
+		group := "lambda-toolbox"
+		input.MessageGroupId = &group
+		input.MessageDeduplicationId = &dedupid
+	}
+	_, err := cli.client.SendMessage(cli.ctx, input)
+	return err
+}
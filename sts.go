@@ -4,7 +4,7 @@ package main
 import (
 	"context"
 
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/aws-sdk-go-v2/service/sts/types"
 )
@@ -12,31 +12,43 @@ import (
 type STSClient struct {
 	InstanceIds []string
 	VpcId       *string
+	ctx         context.Context
 	client      *sts.Client
 }
 
-func NewSTSClient() (*STSClient, error) {
-	cfg, err := config.LoadDefaultConfig(context.TODO())
-	if err != nil {
-		return nil, err
-	}
-	client := &STSClient{
+func NewSTSClient(ctx context.Context, cfg aws.Config) *STSClient {
+	return &STSClient{
+		ctx:    ctx,
 		client: sts.NewFromConfig(cfg),
 	}
-	return client, nil
 }
 
 func (cli *STSClient) AssumeRole(arn string) (*types.Credentials, error) {
-	session := "session"
+	return cli.AssumeRoleWith(arn, "", "session")
+}
+
+func (cli *STSClient) AssumeRoleWith(arn, externalid, session string) (*types.Credentials, error) {
 	duration := int32(3600)
 	input := &sts.AssumeRoleInput{
 		RoleArn:         &arn,
 		RoleSessionName: &session,
 		DurationSeconds: &duration,
 	}
-	output, err := cli.client.AssumeRole(context.TODO(), input)
+	if externalid != "" {
+		input.ExternalId = &externalid
+	}
+	output, err := cli.client.AssumeRole(cli.ctx, input)
 	if err != nil {
 		return nil, err
 	}
 	return output.Credentials, nil
 }
+
+// GetCallerIdentity returns the account and the ARN of the caller
+func (cli *STSClient) GetCallerIdentity() (string, string, error) {
+	output, err := cli.client.GetCallerIdentity(cli.ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", "", err
+	}
+	return strval(output.Account), strval(output.Arn), nil
+}
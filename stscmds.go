@@ -0,0 +1,62 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"net/http"
+)
+
+func init() {
+	RegisterCommands(
+		&Command{
+			Name:        "sts.switch",
+			Description: "assume a role and show the credentials",
+			Required:    []string{"arn"},
+			Handler:     (*Session).doSTSSwitch,
+			Sensitive:   true,
+		},
+		&Command{
+			Name:        "sts.whoami",
+			Description: "show the account and ARN which run the commands, use with role",
+			Handler:     (*Session).doSTSWhoAmI,
+		},
+	)
+}
+
+func (s *Session) doSTSWhoAmI(req PostRequest) {
+	cfg, err := s.awsConfig(req)
+	if err != nil {
+		s.Failf(http.StatusInternalServerError, "LoadAWSConfig: %v", err)
+		return
+	}
+	cli := NewSTSClient(s.ctx, cfg)
+	account, arn, err := cli.GetCallerIdentity()
+	if err != nil {
+		s.Errorf("GetCallerIdentity: %v", err)
+		return
+	}
+	s.Logf("%s %s", account, arn)
+	s.SetData(map[string]string{
+		"account": account,
+		"arn":     arn,
+	})
+}
+
+func (s *Session) doSTSSwitch(req PostRequest) {
+	cfg, err := s.awsConfig(req)
+	if err != nil {
+		s.Failf(http.StatusInternalServerError, "LoadAWSConfig: %v", err)
+		return
+	}
+	cli := NewSTSClient(s.ctx, cfg)
+	cred, err := cli.AssumeRole(*req.ARN)
+	if err != nil {
+		s.Errorf("AssumeRole: %v", err)
+		return
+	}
+	s.Logf("%s %s %s", *cred.AccessKeyId, *cred.SecretAccessKey, *cred.SessionToken)
+	s.SetData(map[string]string{
+		"accesskeyid":     *cred.AccessKeyId,
+		"secretaccesskey": *cred.SecretAccessKey,
+		"sessiontoken":    *cred.SessionToken,
+	})
+}
@@ -0,0 +1,179 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// reference to an output of a previous step like ${steps.vol.volumeid}
+var varRef = regexp.MustCompile(`\$\{([^}]*)\}`)
+
+type stepMap struct {
+	mu      sync.Mutex
+	results map[string]*Result
+}
+
+func newStepMap() *stepMap {
+	return &stepMap{results: map[string]*Result{}}
+}
+
+// reserve returns false if the id is already used
+func (m *stepMap) reserve(id string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.results[id]; ok {
+		return false
+	}
+	m.results[id] = nil
+	return true
+}
+
+// set makes the finished result visible to other steps
+func (m *stepMap) set(id string, res *Result) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.results[id] = res
+}
+
+func (m *stepMap) get(id string) (*Result, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	res, ok := m.results[id]
+	return res, ok
+}
+
+// Output records a named output of the current sub-request
+func (s *Session) Output(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.result == nil {
+		return
+	}
+	if s.result.Outputs == nil {
+		s.result.Outputs = map[string]interface{}{}
+	}
+	s.result.Outputs[key] = value
+}
+
+func (s *Session) lookupVar(name string) (interface{}, error) {
+	a := strings.Split(name, ".")
+	if len(a) != 3 || a[0] != "steps" {
+		return nil, fmt.Errorf("bad reference ${%s}", name)
+	}
+	res, ok := s.steps.get(a[1])
+	if !ok {
+		return nil, fmt.Errorf("unknown step in ${%s}", name)
+	}
+	if res == nil {
+		return nil, fmt.Errorf("step %s has not finished", a[1])
+	}
+	switch a[2] {
+	case "status":
+		return res.Status, nil
+	case "error":
+		return res.Error, nil
+	}
+	v, ok := res.Outputs[a[2]]
+	if !ok {
+		return nil, fmt.Errorf("step %s has no output %s", a[1], a[2])
+	}
+	return v, nil
+}
+
+// expandString replaces references in the string.
+// A string which is a single reference becomes the referred value as is.
+func (s *Session) expandString(str string) (interface{}, error) {
+	if m := varRef.FindStringSubmatch(str); m != nil && m[0] == str {
+		return s.lookupVar(m[1])
+	}
+	var lasterr error
+	out := varRef.ReplaceAllStringFunc(str, func(ref string) string {
+		v, err := s.lookupVar(ref[2 : len(ref)-1])
+		if err != nil {
+			lasterr = err
+			return ref
+		}
+		switch v := v.(type) {
+		case []string:
+			return strings.Join(v, ",")
+		}
+		return fmt.Sprintf("%v", v)
+	})
+	return out, lasterr
+}
+
+func (s *Session) expand(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return s.expandString(v)
+	case []interface{}:
+		list := []interface{}{}
+		for _, e := range v {
+			ev, err := s.expand(e)
+			if err != nil {
+				return nil, err
+			}
+			// a reference to a list in a list is flattened
+			if ids, ok := ev.([]string); ok {
+				for _, id := range ids {
+					list = append(list, id)
+				}
+				continue
+			}
+			list = append(list, ev)
+		}
+		return list, nil
+	case map[string]interface{}:
+		for key, e := range v {
+			ev, err := s.expand(e)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = ev
+		}
+		return v, nil
+	}
+	return v, nil
+}
+
+// resolveRequest replaces references to outputs of previous steps in the request
+func (s *Session) resolveRequest(req PostRequest) (PostRequest, error) {
+	raw, err := json.Marshal(req)
+	if err != nil {
+		return req, err
+	}
+	if !strings.Contains(string(raw), "${") {
+		return req, nil
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return req, err
+	}
+	// nested requests are resolved when they run, templates when the macro runs
+	nested := m["requests"]
+	delete(m, "requests")
+	tmpl := m["template"]
+	delete(m, "template")
+	if _, err := s.expand(m); err != nil {
+		return req, err
+	}
+	if nested != nil {
+		m["requests"] = nested
+	}
+	if tmpl != nil {
+		m["template"] = tmpl
+	}
+	raw, err = json.Marshal(m)
+	if err != nil {
+		return req, err
+	}
+	var resolved PostRequest
+	if err := json.Unmarshal(raw, &resolved); err != nil {
+		return req, fmt.Errorf("resolved request: %v", err)
+	}
+	return resolved, nil
+}
@@ -0,0 +1,110 @@
+// MIT License Copyright (C) 2022 Hiroshi Shimamoto
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func newTestSession() *Session {
+	s := &Session{steps: newStepMap(), ctx: context.Background()}
+	s.steps.set("vol", &Result{
+		Status:  StatusOK,
+		Outputs: map[string]interface{}{"volumeid": "vol-1", "ids": []string{"i-1", "i-2"}},
+	})
+	s.steps.set("bad", &Result{Status: StatusError, Error: "failed"})
+	s.steps.reserve("running")
+	return s
+}
+
+func TestExpandString(t *testing.T) {
+	tests := []struct {
+		in   string
+		want interface{}
+		err  bool
+	}{
+		{"plain", "plain", false},
+		{"${steps.vol.volumeid}", "vol-1", false},
+		{"${steps.vol.ids}", []string{"i-1", "i-2"}, false},
+		{"id=${steps.vol.volumeid}", "id=vol-1", false},
+		{"ids=${steps.vol.ids}", "ids=i-1,i-2", false},
+		{"${steps.bad.status}", StatusError, false},
+		{"${steps.bad.error}", "failed", false},
+		{"${steps.vol.nothing}", nil, true},
+		{"${steps.none.volumeid}", nil, true},
+		{"${steps.running.volumeid}", nil, true},
+		{"${vol.volumeid}", nil, true},
+	}
+	s := newTestSession()
+	for _, tt := range tests {
+		got, err := s.expandString(tt.in)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err %v", tt.in, err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestResolveRequest(t *testing.T) {
+	s := newTestSession()
+	req := PostRequest{
+		Command:     "ec2.attachvolume",
+		VolumeId:    strp("${steps.vol.volumeid}"),
+		InstanceIds: []string{"i-0", "${steps.vol.ids}"},
+		Requests:    []PostRequest{{Command: "help", Id: "${steps.later.x}"}},
+	}
+	got, err := s.resolveRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.VolumeId != "vol-1" {
+		t.Errorf("volumeid %s", *got.VolumeId)
+	}
+	if !reflect.DeepEqual(got.InstanceIds, []string{"i-0", "i-1", "i-2"}) {
+		t.Errorf("instanceids %v", got.InstanceIds)
+	}
+	// nested requests are resolved when they run
+	if got.Requests[0].Id != "${steps.later.x}" {
+		t.Errorf("nested %v", got.Requests[0].Id)
+	}
+	if _, err := s.resolveRequest(PostRequest{Command: "help", Name: strp("${steps.none.x}")}); err == nil {
+		t.Errorf("unknown step is resolved")
+	}
+}
+
+func TestEvalCondition(t *testing.T) {
+	tests := []struct {
+		cond string
+		want bool
+		err  bool
+	}{
+		{"${steps.vol.status} == ok", true, false},
+		{"${steps.bad.status} == ok", false, false},
+		{"${steps.bad.status} != ok", true, false},
+		{"${steps.vol.volumeid}", true, false},
+		{"false", false, false},
+		{"no", false, false},
+		{"0", false, false},
+		{"yes", true, false},
+		{"${steps.none.status} == ok", false, true},
+	}
+	s := newTestSession()
+	for _, tt := range tests {
+		got, err := s.evalCondition(tt.cond)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err %v", tt.cond, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %v want %v", tt.cond, got, tt.want)
+		}
+	}
+}
+
+func strp(s string) *string {
+	return &s
+}